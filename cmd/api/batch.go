@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight.luismatosgarcia.dev/internal/data"
+	"greenlight.luismatosgarcia.dev/internal/validator"
+	"net/http"
+)
+
+// batchMaxOperations is the maximum number of operations allowed in a single batch request.
+const batchMaxOperations = 100
+
+// errBatchFailed is returned from inside the batch transaction when one of the operations fails, so that the whole
+// transaction is rolled back. The details of the failure are recorded in the operation results.
+var errBatchFailed = errors.New("batch operation failed")
+
+// The batchOperation type holds a single operation in a batch request. The ID is required for updates and deletes.
+// If the Version is provided then the operation only succeeds if the movie still has that version, just like the
+// If-Match header on the single movie endpoints.
+type batchOperation struct {
+	Op      string          `json:"op"`
+	ID      int64           `json:"id,omitempty"`
+	Version int32           `json:"version,omitempty"`
+	Movie   json.RawMessage `json:"movie,omitempty"`
+}
+
+// The batchResult type holds the outcome of a single operation in a batch request.
+type batchResult struct {
+	Op     string      `json:"op"`
+	ID     int64       `json:"id,omitempty"`
+	Status int         `json:"status"`
+	Movie  *data.Movie `json:"movie,omitempty"`
+	Error  any         `json:"error,omitempty"`
+}
+
+// The batchMoviesHandler handles the "POST /v1/movies/batch" endpoint. It accepts a list of create, update and delete
+// operations and runs them in order inside a single transaction. If every operation succeeds the changes are
+// committed, and the response contains the result of each operation. If any operation fails validation, refers to a
+// movie which doesn't exist or hits an edit conflict, then the whole batch is rolled back. The failing operation's
+// result explains why, and every other operation is reported with a 424 Failed Dependency status.
+func (app *application) batchMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Operations []batchOperation `json:"operations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Operations) > 0, "operations", "must contain at least 1 operation")
+	v.Check(len(input.Operations) <= batchMaxOperations, "operations", fmt.Sprintf("must not contain more than %d operations", batchMaxOperations))
+
+	for i, op := range input.Operations {
+		key := fmt.Sprintf("operations[%d]", i)
+
+		v.Check(validator.PermittedValue(op.Op, "create", "update", "delete"), key, "op must be one of create, update or delete")
+		v.Check(op.Op == "create" || op.ID > 0, key, "id must be provided")
+		v.Check(op.Op == "delete" || op.Movie != nil, key, "movie must be provided")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	genres, err := app.models.Genres.Catalogue()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	results := make([]batchResult, len(input.Operations))
+	failed := -1
+
+	err = app.models.WithTx(func(tx data.Models) error {
+		for i, op := range input.Operations {
+			result, err := app.runBatchOperation(tx, op, user.ID, genres)
+			if err != nil {
+				return err
+			}
+
+			results[i] = result
+
+			if result.Status >= http.StatusBadRequest {
+				failed = i
+				return errBatchFailed
+			}
+		}
+
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, errBatchFailed):
+		// Report every other operation as failed because of the one that went wrong. The ones before it were rolled
+		// back, and the ones after it never ran.
+		for i := range results {
+			if i == failed {
+				continue
+			}
+
+			results[i] = batchResult{
+				Op:     input.Operations[i].Op,
+				ID:     input.Operations[i].ID,
+				Status: http.StatusFailedDependency,
+				Error:  fmt.Sprintf("not applied because operation %d failed", failed),
+			}
+		}
+
+		env := envelope{
+			"error":   fmt.Sprintf("operation %d failed, so the batch was rolled back", failed),
+			"results": results,
+		}
+
+		err = app.writeResponse(w, r, results[failed].Status, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The runBatchOperation() method runs a single batch operation using the transaction-bound models. Failures caused by
+// the operation itself (such as invalid data or an edit conflict) are returned in the result with a 4xx status code,
+// while unexpected errors are returned as an error. The movies' genres are checked against the given catalogue.
+func (app *application) runBatchOperation(tx data.Models, op batchOperation, userID int64, genres data.GenreCatalogue) (batchResult, error) {
+	result := batchResult{Op: op.Op, ID: op.ID}
+
+	fail := func(status int, message any) (batchResult, error) {
+		result.Status = status
+		result.Error = message
+		return result, nil
+	}
+
+	// Decode the movie fields for creates and updates, in the same way that readJSON() does for a request body.
+	var changes movieChanges
+
+	if op.Op != "delete" {
+		dec := json.NewDecoder(bytes.NewReader(op.Movie))
+		dec.DisallowUnknownFields()
+
+		err := dec.Decode(&changes)
+		if err != nil {
+			return fail(http.StatusBadRequest, fmt.Sprintf("movie contains invalid JSON: %s", err))
+		}
+	}
+
+	switch op.Op {
+	case "create":
+		movie := &data.Movie{}
+		changes.apply(movie)
+
+		v := validator.New()
+
+		if data.ValidateMovie(v, movie, genres); !v.Valid() {
+			return fail(http.StatusUnprocessableEntity, v.Errors)
+		}
+
+		err := tx.Movies.Insert(movie, userID)
+		if err != nil {
+			return result, err
+		}
+
+		result.ID = movie.ID
+		result.Status = http.StatusCreated
+		result.Movie = movie
+
+	case "update":
+		movie, err := tx.Movies.Get(op.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return fail(http.StatusNotFound, "the requested resource could not be found")
+			default:
+				return result, err
+			}
+		}
+
+		if op.Version > 0 && op.Version != movie.Version {
+			return fail(http.StatusConflict, "unable to update the record due to an edit conflict")
+		}
+
+		changes.apply(movie)
+
+		v := validator.New()
+
+		if data.ValidateMovie(v, movie, genres); !v.Valid() {
+			return fail(http.StatusUnprocessableEntity, v.Errors)
+		}
+
+		err = tx.Movies.Update(movie, userID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				return fail(http.StatusConflict, "unable to update the record due to an edit conflict")
+			default:
+				return result, err
+			}
+		}
+
+		result.Status = http.StatusOK
+		result.Movie = movie
+
+	case "delete":
+		err := tx.Movies.Delete(op.ID, op.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return fail(http.StatusNotFound, "the requested resource could not be found")
+			case errors.Is(err, data.ErrEditConflict):
+				return fail(http.StatusConflict, "unable to delete the record due to an edit conflict")
+			default:
+				return result, err
+			}
+		}
+
+		result.Status = http.StatusOK
+	}
+
+	return result, nil
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight.luismatosgarcia.dev/internal/data"
+	"greenlight.luismatosgarcia.dev/internal/validator"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bulkMaxBytes is the maximum size of a bulk import request body. This is a lot larger than the 1MB limit that
+// readJSON() applies, as a single request is expected to contain thousands of movies.
+const bulkMaxBytes = 32 << 20
+
+// The bulkRowError type holds the validation errors for a single row of a bulk import. Rows are numbered from 1, and
+// for CSV input the header line is not counted.
+type bulkRowError struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+// The bulkInput type is the decode destination for a single movie in a bulk import. It has the same fields as the
+// input struct in the createMovieHandler, plus the (unexported) number of the row that it was read from.
+type bulkInput struct {
+	Title   string       `json:"title"`
+	Year    int32        `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
+	row     int
+}
+
+// The importMoviesHandler handles the "POST /v1/movies/bulk" endpoint. It accepts either NDJSON (one JSON movie
+// object per line) or CSV (with a title,year,runtime,genres header line) and validates every row with
+// ValidateMovie(). If any row is invalid then nothing is inserted, and the client gets a report listing the errors
+// for each failing row. Otherwise, all the movies are inserted in a single transaction.
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, bulkMaxBytes)
+
+	var inputs []bulkInput
+	var rowErrors []bulkRowError
+
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson":
+		inputs, rowErrors, err = app.readNDJSONMovies(r.Body)
+	case "text/csv":
+		inputs, rowErrors, err = app.readCSVMovies(r.Body)
+	default:
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	}
+
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	if len(inputs) == 0 && len(rowErrors) == 0 {
+		app.badRequestResponse(w, r, errors.New("body must contain at least one movie"))
+		return
+	}
+
+	genres, err := app.models.Genres.Catalogue()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Copy each decoded row into a Movie struct and validate it, collecting the errors for every failing row
+	// rather than stopping at the first one.
+	movies := make([]*data.Movie, 0, len(inputs))
+
+	for _, input := range inputs {
+		movie := &data.Movie{
+			Title:   input.Title,
+			Year:    input.Year,
+			Runtime: input.Runtime,
+			Genres:  input.Genres,
+		}
+
+		v := validator.New()
+
+		if data.ValidateMovie(v, movie, genres); !v.Valid() {
+			rowErrors = append(rowErrors, bulkRowError{Row: input.row, Errors: v.Errors})
+			continue
+		}
+
+		movies = append(movies, movie)
+	}
+
+	if len(rowErrors) > 0 {
+		// The decoding and validation errors were collected separately, so put them back in row order.
+		sort.Slice(rowErrors, func(i, j int) bool { return rowErrors[i].Row < rowErrors[j].Row })
+
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, envelope{"rows": rowErrors})
+		return
+	}
+
+	err = app.models.Movies.InsertMany(movies, app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"imported": len(movies)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readNDJSONMovies() helper decodes one movie per line of the body. Blank lines are skipped. Rows which can't be
+// decoded are recorded as row errors, while problems with the body as a whole are returned as an error.
+func (app *application) readNDJSONMovies(body io.Reader) ([]bulkInput, []bulkRowError, error) {
+	var inputs []bulkInput
+	var rowErrors []bulkRowError
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1_048_576)
+
+	row := 0
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		row++
+
+		var input bulkInput
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+
+		err := dec.Decode(&input)
+		if err != nil {
+			rowErrors = append(rowErrors, bulkRowError{Row: row, Errors: map[string]string{"json": err.Error()}})
+			continue
+		}
+
+		input.row = row
+		inputs = append(inputs, input)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return inputs, rowErrors, nil
+}
+
+// The readCSVMovies() helper decodes movies from CSV. The first line must be a header naming the title, year,
+// runtime and genres columns (in any order). The runtime can be in any format accepted by data.ParseRuntime(), such
+// as a plain number of minutes, "102 mins", "1h 42m" or "PT1H42M", and the genres are separated by commas within
+// their field.
+func (app *application) readCSVMovies(body io.Reader) ([]bulkInput, []bulkRowError, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil, errors.New("body must not be empty")
+		}
+		return nil, nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("csv header must contain a %q column", name)
+		}
+	}
+
+	var inputs []bulkInput
+	var rowErrors []bulkRowError
+
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		// A csv.ParseError only affects the current record, so record it against the row and carry on. Any other
+		// error (such as the body being too large) is returned straight away.
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			rowErrors = append(rowErrors, bulkRowError{Row: row, Errors: map[string]string{"csv": parseError.Err.Error()}})
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		input := bulkInput{Title: record[columns["title"]], row: row}
+		errs := make(map[string]string)
+
+		year, err := strconv.ParseInt(strings.TrimSpace(record[columns["year"]]), 10, 32)
+		if err != nil {
+			errs["year"] = "must be an integer value"
+		}
+		input.Year = int32(year)
+
+		runtime, err := data.ParseRuntime(record[columns["runtime"]])
+		if err != nil {
+			errs["runtime"] = err.Error()
+		}
+		input.Runtime = runtime
+
+		if genres := strings.TrimSpace(record[columns["genres"]]); genres != "" {
+			for _, genre := range strings.Split(genres, ",") {
+				input.Genres = append(input.Genres, strings.TrimSpace(genre))
+			}
+		}
+
+		if len(errs) > 0 {
+			rowErrors = append(rowErrors, bulkRowError{Row: row, Errors: errs})
+			continue
+		}
+
+		inputs = append(inputs, input)
+	}
+
+	return inputs, rowErrors, nil
+}
+
+// The exportMoviesHandler handles the "GET /v1/movies/export" endpoint. It accepts the same title, genres and sort
+// query string parameters as the listMoviesHandler, but rather than returning a page of results it streams every
+// matching movie to the client, in either CSV or NDJSON format. Each movie is written as soon as it is read from the
+// database, so the catalogue is never loaded into memory in one go.
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title  string
+		Genres []string
+		Format string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Title = app.readString(qs, "title", "")
+	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Format = app.readString(qs, "format", "ndjson")
+
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafeList = []string{
+		"id", "title", "year", "runtime", "average_rating", "rating_count",
+		"-id", "-title", "-year", "-runtime", "-average_rating", "-rating_count",
+	}
+
+	// The export isn't paginated, so we only need to check the sort value rather than calling ValidateFilters().
+	v.Check(validator.PermittedValue(input.Filters.Sort, input.Filters.SortSafeList...), "sort", "invalid sort value")
+	v.Check(validator.PermittedValue(input.Format, "csv", "ndjson"), "format", "must be either csv or ndjson")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Pick the function which writes a single movie in the requested format, and the function which finishes the
+	// response off. The csv.Writer is buffered, so we flush it every time a movie is written to keep the response
+	// streaming.
+	var writeMovie func(*data.Movie) error
+	var finish func() error
+
+	switch input.Format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.csv"`)
+
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "title", "year", "runtime", "genres", "version"})
+
+		writeMovie = func(movie *data.Movie) error {
+			cw.Write([]string{
+				strconv.FormatInt(movie.ID, 10),
+				movie.Title,
+				strconv.Itoa(int(movie.Year)),
+				strconv.Itoa(int(movie.Runtime)),
+				strings.Join(movie.Genres, ","),
+				strconv.Itoa(int(movie.Version)),
+			})
+			cw.Flush()
+			return cw.Error()
+		}
+
+		finish = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+
+		enc := json.NewEncoder(w)
+
+		writeMovie = func(movie *data.Movie) error {
+			return enc.Encode(movie)
+		}
+		finish = func() error { return nil }
+	}
+
+	// The server's WriteTimeout would cut a large export off part way through the body, after the 200 has already
+	// been sent, so we push the write deadline back before each movie is written, like the changes feed does. A client
+	// which stops reading still times out.
+	rc := http.NewResponseController(w)
+
+	// Keep track of whether anything has been written yet. Once the first movie has been written the status code and
+	// headers have already been sent, so we can't send an error response any more and all we can do is log the error.
+	written := false
+
+	err := app.models.Movies.Stream(r.Context(), input.Title, input.Genres, input.Filters, func(movie *data.Movie) error {
+		err := rc.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		if err != nil {
+			return err
+		}
+
+		written = true
+		return writeMovie(movie)
+	})
+	if err != nil {
+		if !written {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.logError(r, err)
+		return
+	}
+
+	err = finish()
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/lib/pq"
+	"greenlight.luismatosgarcia.dev/internal/data"
+	"greenlight.luismatosgarcia.dev/internal/validator"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A changeBroker passes on the notifications of movie changes to every open changes feed. The notifications don't
+// carry the changes themselves, they just wake the feeds up so that they read the new changes from the database.
+type changeBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan struct{}]bool
+	closed      bool
+}
+
+// The newChangeBroker() function returns a changeBroker with no subscribers.
+func newChangeBroker() *changeBroker {
+	return &changeBroker{subscribers: make(map[chan struct{}]bool)}
+}
+
+// The subscribe() method returns a channel which receives a value whenever there might be new changes, along with a
+// function to unsubscribe. The channel is closed when the broker is closed.
+func (b *changeBroker) subscribe() (<-chan struct{}, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// The channel is buffered, so that a notification which arrives while the feed is busy isn't lost.
+	ch := make(chan struct{}, 1)
+
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	b.subscribers[ch] = true
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if b.subscribers[ch] {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// The broadcast() method wakes up every subscriber. Subscribers which already have a notification waiting are skipped,
+// as they are going to check for new changes anyway.
+func (b *changeBroker) broadcast() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// The close() method closes every subscriber's channel, which ends their feeds, and stops any new subscriptions.
+func (b *changeBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+
+	b.closed = true
+}
+
+// The listenForMovieChanges() method starts a background worker which listens on the Postgres channel that the movies
+// trigger notifies, and passes the notifications on to the change broker. The pq.Listener reconnects by itself if the
+// connection is lost, and as notifications could have been missed while it was down, we wake up every feed once it is
+// back. When the done channel is closed the listener is closed. The open feeds have already been ended by then, as
+// serve() closes the change broker as soon as the server starts shutting down, so that they don't hold up the
+// graceful shutdown.
+func (app *application) listenForMovieChanges(done <-chan struct{}) {
+	listener := pq.NewListener(app.config.db.dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"channel": data.MovieChangesChannel})
+		}
+	})
+
+	err := listener.Listen(data.MovieChangesChannel)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"channel": data.MovieChangesChannel})
+	}
+
+	app.background(func() {
+		defer app.changes.close()
+		defer listener.Close()
+
+		for {
+			select {
+			case <-done:
+				return
+
+			// A nil notification means that the connection was re-established.
+			case <-listener.Notify:
+				app.changes.broadcast()
+
+			// Check the connection every now and then, as the listener doesn't notice that it has been lost until it
+			// tries to use it.
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+		}
+	})
+}
+
+// The movieChangesHandler handles the "GET /v1/movies/changes" endpoint, which streams the changes to the movies as
+// they happen. By default the changes are sent as server-sent events, with the change ID as the event ID and the
+// operation (create, update or delete) as the event type, but a client that asks for application/x-ndjson gets one
+// JSON object per line instead.
+//
+// The feed starts from the change after the one in the Last-Event-ID header (which browsers send by themselves when
+// they reconnect) or the "after" query string parameter. Without either, only the changes made after the request are
+// sent. Changes are only kept for a limited time, so a client which has been away for longer than that should
+// re-read the movies instead.
+func (app *application) movieChangesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	after := r.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = r.URL.Query().Get("after")
+	}
+
+	var afterID int64
+
+	if after != "" {
+		id, err := strconv.ParseInt(after, 10, 64)
+		v.Check(err == nil && id >= 0, "after", "must be a change ID")
+		afterID = id
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Subscribe before reading the last change ID, so that we can't miss a change made in between.
+	notifications, unsubscribe := app.changes.subscribe()
+	defer unsubscribe()
+
+	if after == "" {
+		var err error
+
+		afterID, err = app.models.MovieChanges.LastID()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	format, ok := negotiateFormat(r.Header.Get("Accept"), changeFeedFormats)
+	if !ok {
+		format = changeFeedFormats[0]
+	}
+
+	// The server's WriteTimeout would cut the feed off after 30 seconds, so we use a ResponseController to set the
+	// write deadline ourselves before each write instead. That way the feed stays open, but a client which stops
+	// reading still times out.
+	rc := http.NewResponseController(w)
+
+	write := func(p []byte) error {
+		err := rc.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(p)
+		if err != nil {
+			return err
+		}
+
+		return rc.Flush()
+	}
+
+	w.Header().Set("Content-Type", format.mediaType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// Send the headers straight away, so that the client knows the feed has started. Server-sent events clients are
+	// also told to wait 5 seconds before reconnecting, if the connection is lost.
+	var preamble []byte
+	if format == changeFeedFormats[0] {
+		preamble = []byte("retry: 5000\n\n")
+	}
+
+	err := write(preamble)
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	// Even without notifications we check for changes (and send a comment, to keep the connection open through any
+	// proxies) every 15 seconds. This also picks up changes which were held back behind a long-running transaction
+	// when they were notified, as that transaction might not notify anything itself when it finishes.
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		// Send all the changes since the last one that we sent, in batches.
+		for {
+			changes, err := app.models.MovieChanges.GetAllAfter(afterID, 100)
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+
+			for _, change := range changes {
+				js, err := json.Marshal(change)
+				if err != nil {
+					app.logError(r, err)
+					return
+				}
+
+				var event []byte
+				if format == changeFeedFormats[0] {
+					event = []byte(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", change.ID, change.Operation, js))
+				} else {
+					event = append(js, '\n')
+				}
+
+				err = write(event)
+				if err != nil {
+					app.logError(r, err)
+					return
+				}
+
+				afterID = change.ID
+			}
+
+			if len(changes) < 100 {
+				break
+			}
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+
+		case _, ok := <-notifications:
+			// The broker closes the channel when the server is shutting down.
+			if !ok {
+				return
+			}
+
+		case <-heartbeat.C:
+			if format == changeFeedFormats[0] {
+				err := write([]byte(": keep-alive\n\n"))
+				if err != nil {
+					app.logError(r, err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// The changeFeedFormats slice holds the formats that the changes feed can be sent in. Server-sent events come first,
+// so that they are used unless the client asks for NDJSON.
+var changeFeedFormats = []*responseFormat{
+	{mediaType: "text/event-stream"},
+	{mediaType: "application/x-ndjson"},
+}
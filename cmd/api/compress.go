@@ -0,0 +1,223 @@
+package main
+
+import (
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"github.com/felixge/httpsnoop"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The contentEncodings slice holds the content codings that we can compress responses with, in our order of
+// preference. Brotli usually gives smaller responses than gzip, so we use it whenever the client accepts both equally.
+var contentEncodings = []string{"br", "gzip"}
+
+// The negotiateEncoding() function picks the content coding for an Accept-Encoding header value. The coding with the
+// highest quality value wins, with ties going to our order of preference. A "*" matches any coding which isn't
+// listed on its own. It returns false if the client doesn't accept any of our codings, in which case the response is
+// sent uncompressed.
+func negotiateEncoding(header string) (string, bool) {
+	qualities := make(map[string]float64)
+
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+
+		if name, value, found := strings.Cut(strings.TrimSpace(params), "="); found && strings.TrimSpace(name) == "q" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		qualities[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+
+	for _, coding := range contentEncodings {
+		q, ok := qualities[coding]
+		if !ok {
+			q = qualities["*"]
+		}
+
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	return best, best != ""
+}
+
+// The compressibleType() function reports whether a response with the given Content-Type is worth compressing. Images
+// (like the movie posters) are already compressed, so we leave them alone.
+func compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"), strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+
+	switch mediaType {
+	case "application/json", "application/xml", "application/x-ndjson", "application/msgpack":
+		return true
+	default:
+		return false
+	}
+}
+
+// A compressWriter holds back the start of a response until it knows whether the response is big enough to be worth
+// compressing. Once minSize bytes have been written (or the handler flushes the response), the headers are sent with a
+// Content-Encoding header and the rest of the body goes through the compressor. If the handler returns before then,
+// the response is sent uncompressed by close().
+type compressWriter struct {
+	w        http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser
+}
+
+// The writeHeader() method records the status code, which isn't sent until we know whether to compress the response.
+// Responses that never have a body are sent straight away.
+func (cw *compressWriter) writeHeader(status int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+
+	cw.status = status
+
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+// The Write() method buffers the start of the body, and then writes the rest through the compressor (if any). It is
+// exported so that a compressWriter can be used as an io.Writer when the handler copies a body with ReadFrom().
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+
+		if len(cw.buf) < cw.minSize {
+			return len(p), nil
+		}
+
+		err := cw.decide(true)
+		if err != nil {
+			return 0, err
+		}
+
+		return len(p), nil
+	}
+
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+
+	return cw.w.Write(p)
+}
+
+// The flush() method sends everything written so far to the client. Handlers only flush responses which they are
+// streaming, so we decide to compress it (if it is a suitable type) without waiting for minSize bytes.
+func (cw *compressWriter) flush(next httpsnoop.FlushFunc) {
+	if !cw.decided {
+		cw.decide(true)
+	}
+
+	if flusher, ok := cw.enc.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+
+	next()
+}
+
+// The decide() method sends the headers, with a Content-Encoding header if compress is true and the response is
+// suitable, and then writes out the buffered start of the body.
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+
+	header := cw.w.Header()
+
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	// Leave alone responses which are already encoded, partial content (as the byte ranges are of the uncompressed
+	// body) and types which don't compress well.
+	if header.Get("Content-Encoding") != "" || cw.status == http.StatusPartialContent || !compressibleType(header.Get("Content-Type")) {
+		compress = false
+	}
+
+	if compress {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+
+		// A strong ETag promises the exact bytes of the response, which the compressed body no longer matches (and
+		// the same tag is sent with other encodings). So we turn it into a weak one, which only promises the same
+		// content.
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+
+		switch cw.encoding {
+		case "br":
+			cw.enc = brotli.NewWriter(cw.w)
+		case "gzip":
+			cw.enc = gzip.NewWriter(cw.w)
+		}
+	}
+
+	cw.w.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	if cw.enc != nil {
+		_, err := cw.enc.Write(buf)
+		return err
+	}
+
+	_, err := cw.w.Write(buf)
+	return err
+}
+
+// The close() method finishes the response once the handler has returned. A response which didn't reach minSize
+// bytes is sent uncompressed, and a compressed response has the rest of its compressed data written out.
+func (cw *compressWriter) close() error {
+	if !cw.decided {
+		// If the handler didn't write anything at all then there's nothing to send. The standard library will send a
+		// 200 OK response with no body, as it would have done without us.
+		if cw.status == 0 && len(cw.buf) == 0 {
+			return nil
+		}
+
+		return cw.decide(false)
+	}
+
+	if cw.enc != nil {
+		return cw.enc.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"greenlight.luismatosgarcia.dev/internal/settings"
+	"greenlight.luismatosgarcia.dev/internal/validator"
+	"io"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// configOptions says where the settings are read from besides the command line. Each flag can also be set in the
+// config file given by -config (or GREENLIGHT_CONFIG), or in an environment variable named after it, such as
+// GREENLIGHT_DB_DSN for -db-dsn. The command line beats the environment, which beats the config file.
+var configOptions = settings.Options{
+	EnvPrefix:       "GREENLIGHT_",
+	FileFlag:        "config",
+	CommandLineOnly: []string{"version", "print-config"},
+	Secrets:         []string{"smtp-password"},
+}
+
+// fieldsFlag is the flag.Value for a list of settings separated by whitespace. Unlike a flag.Func(), it can print
+// its value, which -print-config needs.
+type fieldsFlag []string
+
+func (f *fieldsFlag) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *fieldsFlag) Set(value string) error {
+	*f = strings.Fields(value)
+	return nil
+}
+
+// The validateConfig() function checks the whole config at startup, so that a mistake is reported straight away with
+// the name of the flag to fix, rather than turning up later as a confusing failure. Each check is keyed by the name of
+// its flag.
+func validateConfig(v *validator.Validator, cfg config) {
+	v.Check(cfg.port >= 1 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.PermittedValue(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+
+	v.Check(cfg.db.dsn != "", "db-dsn", "must be provided")
+	v.Check(cfg.db.maxOpenConns >= 1, "db-max-open-conns", "must be at least 1")
+	v.Check(cfg.db.maxIdleConns >= 0, "db-max-idle-conns", "must not be negative")
+	v.Check(cfg.db.maxIdleConns <= cfg.db.maxOpenConns, "db-max-idle-conns", "must not be more than db-max-open-conns")
+
+	if d, err := time.ParseDuration(cfg.db.maxIdleTime); err != nil || d <= 0 {
+		v.AddError("db-max-idle-time", "must be a positive duration, such as 15m")
+	}
+
+	if cfg.limiter.enabled {
+		v.Check(cfg.limiter.rps > 0, "limiter-rps", "must be greater than zero")
+		v.Check(cfg.limiter.burst >= 1, "limiter-burst", "must be at least 1")
+	}
+
+	v.Check(validator.PermittedValue(cfg.smtp.mode, "smtp", "mbox", "memory", "noop"), "smtp-mode", "must be smtp, mbox, memory or noop")
+
+	// The other modes never reach a real inbox, and -smtp-mode defaults to mbox for development, so in production it
+	// has to be set to smtp. Otherwise a forgotten flag would quietly stop every email from going out.
+	if cfg.env == "production" {
+		v.Check(cfg.smtp.mode == "smtp", "smtp-mode", "must be smtp when env is production")
+	}
+
+	switch cfg.smtp.mode {
+	case "smtp":
+		v.Check(cfg.smtp.host != "", "smtp-host", "must be provided when smtp-mode is smtp")
+		v.Check(cfg.smtp.port >= 1 && cfg.smtp.port <= 65535, "smtp-port", "must be between 1 and 65535")
+		v.Check(cfg.smtp.password == "" || cfg.smtp.username != "", "smtp-username", "must be provided with smtp-password")
+	case "mbox":
+		v.Check(cfg.smtp.mboxFile != "", "smtp-mbox-file", "must be provided when smtp-mode is mbox")
+	}
+
+	_, err := mail.ParseAddress(cfg.smtp.sender)
+	v.Check(err == nil, "smtp-sender", "must be an email address, such as \"Greenlight <no-reply@example.com>\"")
+
+	for _, origin := range cfg.cors.trustedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+			v.AddError("cors-trusted-origins", fmt.Sprintf("%q is not an origin, such as https://www.example.com", origin))
+		}
+	}
+
+	v.Check(cfg.trash.retention > 0, "trash-retention", "must be greater than zero")
+	v.Check(cfg.trash.purgeInterval > 0, "trash-purge-interval", "must be greater than zero")
+
+	v.Check(cfg.idempotency.ttl > 0, "idempotency-ttl", "must be greater than zero")
+
+	v.Check(cfg.storage.localDir != "", "storage-local-dir", "must be provided")
+
+	v.Check(cfg.responses.compressionMinSize >= 0, "compression-min-size", "must not be negative")
+
+	v.Check(cfg.changes.retention > 0, "changes-retention", "must be greater than zero")
+
+	v.Check(cfg.webhooks.timeout > 0, "webhooks-timeout", "must be greater than zero")
+	v.Check(cfg.webhooks.maxAttempts >= 1, "webhooks-max-attempts", "must be at least 1")
+	v.Check(cfg.webhooks.retention > 0, "webhooks-retention", "must be greater than zero")
+
+	v.Check(cfg.jobs.workers >= 1, "jobs-workers", "must be at least 1")
+	v.Check(cfg.jobs.timeout > 0, "jobs-timeout", "must be greater than zero")
+	v.Check(cfg.jobs.maxAttempts >= 1, "jobs-max-attempts", "must be at least 1")
+	v.Check(cfg.jobs.retention > 0, "jobs-retention", "must be greater than zero")
+
+	if cfg.cache.enabled {
+		v.Check(cfg.cache.size >= 1, "cache-size", "must be at least 1")
+		v.Check(cfg.cache.ttl > 0, "cache-ttl", "must be greater than zero")
+	}
+}
+
+// The printConfigErrors() function writes the problems with the config under a heading, one per line.
+func printConfigErrors(w io.Writer, problems []string) {
+	fmt.Fprintln(w, "invalid configuration:")
+
+	for _, problem := range problems {
+		fmt.Fprintf(w, "  %s\n", problem)
+	}
+}
+
+// The validationProblems() function returns the errors from validateConfig() as lines for printConfigErrors(), sorted
+// by setting.
+func validationProblems(errors map[string]string) []string {
+	problems := make([]string, 0, len(errors))
+	for setting, message := range errors {
+		problems = append(problems, setting+": "+message)
+	}
+
+	sort.Strings(problems)
+
+	return problems
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"greenlight.luismatosgarcia.dev/internal/validator"
+	"testing"
+)
+
+func TestValidateConfigSMTPMode(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		mode string
+		want string
+	}{
+		{"smtp in production", "production", "smtp", ""},
+		{"mbox in production", "production", "mbox", "must be smtp when env is production"},
+		{"memory in production", "production", "memory", "must be smtp when env is production"},
+		{"noop in production", "production", "noop", "must be smtp when env is production"},
+		{"mbox in development", "development", "mbox", ""},
+		{"memory in staging", "staging", "memory", ""},
+		{"unknown mode", "development", "pigeon", "must be smtp, mbox, memory or noop"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config
+			cfg.env = tt.env
+			cfg.smtp.mode = tt.mode
+			cfg.smtp.host = "smtp.example.com"
+			cfg.smtp.port = 587
+			cfg.smtp.mboxFile = "./mail.mbox"
+
+			v := validator.New()
+			validateConfig(v, cfg)
+
+			if got := v.Errors["smtp-mode"]; got != tt.want {
+				t.Errorf("got smtp-mode error %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -17,15 +17,16 @@ func (app *application) logError(r *http.Request, err error) {
 	})
 }
 
-// The errorResponse() method is a generic helper for sending JSON-formatted error messages to the client with a given
-// status code. Note that we're using an any type for the message parameter, rather than just a string type,
-// as this gives us more flexibility over the values that we can include in the response.
+// The errorResponse() method is a generic helper for sending error messages to the client with a given status code,
+// in the response format that the client asked for. Note that we're using an any type for the message parameter,
+// rather than just a string type, as this gives us more flexibility over the values that we can include in the
+// response.
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
 	env := envelope{"error": message}
 
-	// Write the response using the writeJSON() helper. If this happens to return an error then log it, and fall
+	// Write the response using the writeResponse() helper. If this happens to return an error then log it, and fall
 	// back to sending the client an empty response with a 500 Internal Server Error status code.
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeResponse(w, r, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
@@ -70,6 +71,27 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+// The preconditionFailedResponse() method is used when the If-Match header sent by the client doesn't match the
+// current version of the resource.
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since the version given in the If-Match header"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+// The idempotencyKeyReusedResponse() method is used when an Idempotency-Key header is sent again with a different
+// request to the one it was first used for.
+func (app *application) idempotencyKeyReusedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the idempotency key has already been used for a different request"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, message)
+}
+
+// The idempotencyKeyInProgressResponse() method is used when a request is retried with an Idempotency-Key header
+// while the original request is still being processed.
+func (app *application) idempotencyKeyInProgressResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a request with this idempotency key is still being processed, please try again later"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
@@ -101,3 +123,31 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) notReviewOwnerResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you can only change your own reviews"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) duplicateReviewResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you have already reviewed this movie, so please update your existing review instead"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) genreInUseResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the genre can't be deleted while movies still have it"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the %s content type is not supported for this resource", r.Header.Get("Content-Type"))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+// The notAcceptableResponse() method will be used to send a 406 Not Acceptable status code to the client when none of
+// the response formats that it accepts are supported. As the negotiate() middleware hasn't picked a format at that
+// point, writeResponse() falls back to sending the error in JSON.
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the requested response format is not supported, it must be one of application/json, application/xml, text/csv or application/msgpack"
+	app.errorResponse(w, r, http.StatusNotAcceptable, message)
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
+	"greenlight.luismatosgarcia.dev/internal/data"
 	"greenlight.luismatosgarcia.dev/internal/validator"
 	"io"
 	"net/http"
@@ -29,33 +31,87 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
-// Define a writeJSON() helper for sending response. This takes the destination http.ResponseWriter, the HTTP status
-// code to send, the data to encode to JSON, and a header map containing any additional HTTP headers we want to
-// include in the response.
-func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
-	// Encode the data to JSON, returning the error if there was one.
-	js, err := json.MarshalIndent(data, "", "\t")
-	if err != nil {
-		return nil
+// The readVersionParam() helper retrieves the "version" URL parameter from the current request context, in the same
+// way as readIDParam().
+func (app *application) readVersionParam(r *http.Request) (int32, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	version, err := strconv.ParseInt(params.ByName("version"), 10, 32)
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid version parameter")
 	}
 
-	// Append a newline to make it easier to view in terminal applications.
-	js = append(js, '\n')
+	return int32(version), nil
+}
+
+// The readReviewIDParam() helper retrieves the "review_id" URL parameter from the current request context, in the same
+// way as readIDParam().
+func (app *application) readReviewIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
 
-	// At this point, we know that we won't encounter any more errors before writing the response, so it's
-	// safe to add any headers that we want to include. We loop through the header map and add each header
-	// to the http.ResponseWriter header map. Note that it's OK if the provided header map is nil. Go doesn't
-	// throw an error if you try to range over (or generally, read from) a nil map.
-	for key, value := range headers {
-		w.Header()[key] = value
+	id, err := strconv.ParseInt(params.ByName("review_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid review_id parameter")
 	}
 
-	// Add the "Content-Type: application/json" header, then write the status code and JSON response.
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	w.Write(js)
+	return id, nil
+}
+
+// The etag() helper returns the entity tag for a representation of a resource. The tag starts with the resource's
+// version number, which is what If-Match preconditions are checked against (see matchVersion()). It ends with a hash of
+// the representation and the format it is sent in, so that the tag also changes when something changes without
+// bumping the version (such as a movie's rating aggregates, poster or translated titles), and is different for each
+// format, language and set of fields that the resource can be sent with.
+func (app *application) etag(r *http.Request, version int32, representation any) string {
+	hash := sha256.New()
+	hash.Write([]byte(app.contextGetResponseFormat(r).mediaType + "\n"))
+	json.NewEncoder(hash).Encode(representation)
+
+	return fmt.Sprintf(`"%d-%x"`, version, hash.Sum(nil)[:8])
+}
 
-	return nil
+// The matchVersion() helper reports whether the value of an If-Match request header matches the current version of
+// a resource. The header may contain a comma-separated list of tags, or "*" which matches any version. Only the
+// version at the start of each tag is compared, as If-Match is about making sure that the client is changing the
+// version of the resource that it last saw, whichever representation of it that was. For the same reason weak tags
+// (prefixed with W/) are accepted too, as the compress() middleware weakens the tags of the responses that it
+// compresses, and the version in them is still exact.
+func (app *application) matchVersion(header string, version int32) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+
+		if tag == "*" {
+			return true
+		}
+
+		tag = strings.Trim(strings.TrimPrefix(tag, "W/"), `"`)
+		tagVersion, _, _ := strings.Cut(tag, "-")
+
+		if tagVersion == strconv.Itoa(int(version)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The matchETag() helper reports whether the value of an If-None-Match request header matches the current entity
+// tag. The header may contain a comma-separated list of tags, or "*" which matches any tag. If-None-Match uses the
+// weak comparison, which ignores the W/ prefix.
+func (app *application) matchETag(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+
+		if tag == "*" {
+			return true
+		}
+
+		if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
@@ -195,6 +251,41 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// The readBool() helper reads a boolean value from the query string in the same way as readInt(). Any of the values
+// accepted by strconv.ParseBool() can be used, such as "true", "false", "1" or "0".
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// The readRuntimeFormat() helper reads the runtime_format value from the query string, which selects how movie runtimes
+// are written in the response. If it isn't given then the runtime is written in the default "<runtime> mins" format.
+func (app *application) readRuntimeFormat(qs url.Values, v *validator.Validator) data.RuntimeFormat {
+	s := qs.Get("runtime_format")
+
+	if s == "" {
+		return data.RuntimeFormatDefault
+	}
+
+	if !validator.PermittedValue(s, data.RuntimeFormats...) {
+		v.AddError("runtime_format", "must be one of "+strings.Join(data.RuntimeFormats, ", "))
+		return data.RuntimeFormatDefault
+	}
+
+	return data.RuntimeFormat(s)
+}
+
 // The background() helper accepts an arbitrary function as a parameter.
 func (app *application) background(fn func()) {
 	// Increment the WaitGroup counter
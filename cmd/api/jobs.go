@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"greenlight.luismatosgarcia.dev/internal/data"
+	"greenlight.luismatosgarcia.dev/internal/jobs"
+	"greenlight.luismatosgarcia.dev/internal/mailer"
+	"greenlight.luismatosgarcia.dev/internal/validator"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The kinds of background job, which pick the handler that runs each job.
+const (
+	jobWelcomeEmail = "welcome_email"
+)
+
+// The welcomeEmailPayload struct is the payload of a welcome email job. The user is looked up by their ID when the job
+// runs, so that the email goes to their current address.
+type welcomeEmailPayload struct {
+	UserID    int64    `json:"user_id"`
+	Languages []string `json:"languages,omitempty"`
+}
+
+// The registerJobHandlers() method registers the handler for each kind of background job with the job runner.
+func (app *application) registerJobHandlers() {
+	jobs.Register(app.jobs, jobWelcomeEmail, app.sendWelcomeEmail)
+}
+
+// The runJobs() method starts the background worker pool which runs the jobs in the queue. Once the done channel is
+// closed the workers stop claiming jobs, and the server waits for the jobs they are running to finish.
+func (app *application) runJobs(done <-chan struct{}) {
+	app.background(func() {
+		app.jobs.Run(done)
+	})
+}
+
+// The sendWelcomeEmail() method is the handler for welcome email jobs. It creates a new activation token for the user
+// and emails it to them. The token is created here, rather than when the user registers, so that the plaintext token is
+// never stored in the queue. If the job is retried, each attempt creates a new token, but only the one that gets
+// emailed is ever known to anyone.
+//
+// A temporary failure to send the email (such as a 4xx reply from the SMTP server, or a timeout) is returned as it is,
+// so that the job is retried with backoff. A permanent failure (a 5xx reply) ends the job straight away, as does a
+// suppressed recipient, for whom there is nothing more to do.
+func (app *application) sendWelcomeEmail(ctx context.Context, payload welcomeEmailPayload) error {
+	user, err := app.models.Users.Get(payload.UserID)
+	if err != nil {
+		switch {
+		// The user has gone, so there's no one to welcome.
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	// The user has already been activated (with a token from an earlier attempt whose outcome wasn't recorded).
+	if user.Activated {
+		return nil
+	}
+
+	// The job has a time limit, after which its claim on the job runs out and another worker could pick it up. So we
+	// check that there is still time left before creating the token and sending the email, as otherwise the user could
+	// get two emails. Sending itself is bounded by the SMTP timeout.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	// As there are now multiple pieces of data that we want to pass to our email templates, we create a map to act as
+	// a 'holding structure' for the data. This contains the plaintext version of the activation token for the user,
+	// along with their ID.
+	mailData := map[string]any{
+		"activationToken": token.Plaintext,
+		"userId":          user.ID,
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// The email is sent in the first of the languages that the user asked for when they registered which it has been
+	// translated into, falling back to English.
+	err = app.mailer.Send(user.Email, "user_welcome.gohtml", mailData, payload.Languages...)
+	switch {
+	case errors.Is(err, mailer.ErrSuppressed):
+		app.logger.PrintInfo("welcome email not sent to suppressed address", map[string]string{
+			"user_id": strconv.FormatInt(user.ID, 10),
+		})
+		return nil
+	case mailer.IsPermanent(err):
+		return jobs.Permanent(err)
+	default:
+		return err
+	}
+}
+
+// The listJobsHandler handles the "GET /v1/jobs" endpoint, which lists the jobs in the queue. It is mostly useful with
+// ?status=dead, to see the jobs which have run out of attempts along with the error from their last attempt.
+func (app *application) listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status string
+		Kind   string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+	input.Kind = app.readString(qs, "kind", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafeList = []string{"id", "run_at", "-id", "-run_at"}
+
+	if input.Status != "" {
+		v.Check(validator.PermittedValue(input.Status, data.JobQueued, data.JobRunning, data.JobSucceeded, data.JobDead), "status", "must be queued, running, succeeded or dead")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	jobs, metadata, err := app.models.Jobs.GetAll(input.Status, input.Kind, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"jobs": jobs, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The retryJobHandler handles the "POST /v1/jobs/:id/retry" endpoint, which puts a dead job back in the queue with a
+// fresh set of attempts, once whatever made it fail has been fixed. Only dead jobs can be retried.
+func (app *application) retryJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	job, err := app.models.Jobs.Revive(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.jobs.Wake()
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"job": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
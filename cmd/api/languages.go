@@ -0,0 +1,102 @@
+package main
+
+import (
+	"greenlight.luismatosgarcia.dev/internal/data"
+	"greenlight.luismatosgarcia.dev/internal/validator"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxLanguages is the maximum number of language preferences that we take from a request. Anything past this is
+// ignored, so that a huge Accept-Language header can't make the localization queries expensive.
+const maxLanguages = 10
+
+// The readLanguages() helper returns the client's preferred languages, most preferred first. A lang query string
+// parameter takes priority, followed by the languages in the Accept-Language header in order of their quality values.
+// Each language with a region or script (like "pt-br") is followed by its base language ("pt"), so that a movie with
+// a Portuguese title is still localized for a Brazilian Portuguese client. Malformed values in the Accept-Language
+// header are skipped, but an invalid lang parameter is recorded in the validator.
+func (app *application) readLanguages(r *http.Request, v *validator.Validator) []string {
+	var tags []string
+
+	if lang := app.readString(r.URL.Query(), "lang", ""); lang != "" {
+		lang = strings.ToLower(lang)
+
+		if data.ValidateLanguage(v, "lang", lang); !v.Valid() {
+			return nil
+		}
+
+		tags = append(tags, lang)
+	}
+
+	tags = append(tags, parseAcceptLanguage(r.Header.Get("Accept-Language"))...)
+
+	var languages []string
+
+	add := func(tag string) {
+		if len(languages) < maxLanguages && !validator.PermittedValue(tag, languages...) {
+			languages = append(languages, tag)
+		}
+	}
+
+	for _, tag := range tags {
+		add(tag)
+
+		if base, _, found := strings.Cut(tag, "-"); found {
+			add(base)
+		}
+	}
+
+	return languages
+}
+
+// The parseAcceptLanguage() function returns the language tags in an Accept-Language header value, lowercased and
+// sorted by their quality values (highest first). The "*" wildcard and languages with a quality of zero are left
+// out, as they don't name a language that we could pick.
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var entries []weighted
+
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+
+		if tag == "" || tag == "*" || !validator.Matches(tag, validator.LanguageTagRX) {
+			continue
+		}
+
+		q := 1.0
+
+		if name, value, found := strings.Cut(strings.TrimSpace(params), "="); found && strings.TrimSpace(name) == "q" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		if q <= 0 {
+			continue
+		}
+
+		entries = append(entries, weighted{tag: tag, q: q})
+	}
+
+	// Use a stable sort, so that languages with the same quality stay in the order the client listed them.
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].q > entries[j].q
+	})
+
+	tags := make([]string, len(entries))
+	for i, entry := range entries {
+		tags[i] = entry.tag
+	}
+
+	return tags
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"greenlight.luismatosgarcia.dev/internal/data"
+	"greenlight.luismatosgarcia.dev/internal/mailer"
+	"greenlight.luismatosgarcia.dev/internal/validator"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// The mailPreviewData map holds the sample data that each email template is rendered with by the preview endpoint.
+// It has the same keys as the data that the template is really sent with, so (as template execution is strict) a
+// template which uses a key that the sender doesn't provide fails to preview too.
+var mailPreviewData = map[string]map[string]any{
+	"user_welcome.gohtml": {
+		"activationToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+		"userId":          int64(123),
+	},
+}
+
+// The previewMailHandler handles the "GET /debug/mail/:template" endpoint, which is only registered in development. It
+// renders an email template with sample data, so that changes to the templates can be checked in a browser without
+// sending anything. The HTML body is sent by default, and ?part=plain sends the plain-text body instead. The language
+// is picked from the lang parameter or the Accept-Language header, in the same way as for the movie titles, and the
+// subject is sent in the X-Mail-Subject header.
+func (app *application) previewMailHandler(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("template")
+	if !strings.HasSuffix(name, ".gohtml") {
+		name += ".gohtml"
+	}
+
+	sample, ok := mailPreviewData[name]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	languages := app.readLanguages(r, v)
+
+	part := app.readString(r.URL.Query(), "part", "html")
+	v.Check(validator.PermittedValue(part, "html", "plain"), "part", "must be html or plain")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	msg, err := app.mailer.Render(name, sample, languages...)
+	if err != nil {
+		switch {
+		case errors.Is(err, mailer.ErrTemplateNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("X-Mail-Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+
+	if part == "plain" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(msg.PlainBody))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(msg.HTMLBody))
+}
+
+// The listSuppressionsHandler handles the "GET /v1/mail/suppressions" endpoint, which lists the addresses on the
+// email suppression list along with the SMTP server's reply when they bounced. The optional ?email= parameter only
+// returns the addresses which contain it, such as a domain.
+func (app *application) listSuppressionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Email = app.readString(qs, "email", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafeList = []string{"email", "created_at", "-email", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suppressions, metadata, err := app.models.Suppressions.GetAll(input.Email, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"suppressions": suppressions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteSuppressionHandler handles the "DELETE /v1/mail/suppressions/:email" endpoint, which takes an address off
+// the suppression list (once the recipient has fixed their mailbox, say), so that emails are sent to it again.
+func (app *application) deleteSuppressionHandler(w http.ResponseWriter, r *http.Request) {
+	email := httprouter.ParamsFromContext(r.Context()).ByName("email")
+
+	err := app.models.Suppressions.Delete(email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "address successfully removed from the suppression list"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -6,9 +6,14 @@ import (
 	"expvar"
 	"flag"
 	"fmt"
+	"greenlight.luismatosgarcia.dev/internal/cache"
 	"greenlight.luismatosgarcia.dev/internal/data"
+	"greenlight.luismatosgarcia.dev/internal/jobs"
 	"greenlight.luismatosgarcia.dev/internal/jsonlog"
 	"greenlight.luismatosgarcia.dev/internal/mailer"
+	"greenlight.luismatosgarcia.dev/internal/settings"
+	"greenlight.luismatosgarcia.dev/internal/storage"
+	"greenlight.luismatosgarcia.dev/internal/validator"
 	"greenlight.luismatosgarcia.dev/internal/vcs"
 	"os"
 	"runtime"
@@ -27,7 +32,8 @@ var (
 // Define a config struct to hold all the configuration settings for our application. For now, the only
 // configuration settings will be the network port that we want the server to listen on, and the name of the current
 // operating environment for the application (development, staging, production, etc.). We will read in these
-// configuration settings from command-line flags when the application starts.
+// configuration settings from command-line flags when the application starts. Every flag can also be set in a config
+// file or an environment variable (see configOptions).
 type config struct {
 	port int
 	env  string
@@ -46,28 +52,94 @@ type config struct {
 		enabled bool
 	}
 
+	// The smtp struct holds the settings for sending emails. The mode picks how they are sent: through the SMTP
+	// server, into the mbox file, kept in memory, or not at all.
 	smtp struct {
+		mode     string
 		host     string
 		port     int
 		username string
 		password string
 		sender   string
+		mboxFile string
 	}
 
 	cors struct {
 		trustedOrigins []string
 	}
+
+	// The trash struct holds how long deleted movies are kept before they are permanently removed, and how often
+	// the purge worker checks for them.
+	trash struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
+
+	// The idempotency struct holds how long the responses to requests with an Idempotency-Key header are kept for
+	// replaying to retries.
+	idempotency struct {
+		ttl time.Duration
+	}
+
+	// The storage struct holds the settings for where uploaded files, such as movie posters, are saved.
+	storage struct {
+		localDir string
+	}
+
+	// The responses struct holds the settings for how responses are encoded: whether JSON and XML are indented for
+	// readability or sent compact, and whether (and above what size) responses are compressed.
+	responses struct {
+		pretty             bool
+		compression        bool
+		compressionMinSize int
+	}
+
+	// The changes struct holds how long the entries in the movie changes feed are kept for.
+	changes struct {
+		retention time.Duration
+	}
+
+	// The webhooks struct holds the settings for sending webhook deliveries: how long receivers have to respond, how
+	// many times a delivery is attempted before it is marked as failed, and how long finished deliveries are kept in the
+	// delivery log.
+	webhooks struct {
+		timeout     time.Duration
+		maxAttempts int
+		retention   time.Duration
+	}
+
+	// The jobs struct holds the settings for the background job queue: how many workers run jobs at the same time,
+	// how long each job can run for, how many times a job is attempted before it is dead, and how long jobs which
+	// succeeded are kept.
+	jobs struct {
+		workers     int
+		timeout     time.Duration
+		maxAttempts int
+		retention   time.Duration
+	}
+
+	// The cache struct holds the settings for the in-memory cache of movie responses: how many responses it holds and
+	// how long each one is kept for.
+	cache struct {
+		enabled bool
+		size    int
+		ttl     time.Duration
+	}
 }
 
 // Define an application struct to hold the dependencies for HTTP handlers, helpers, and middleware. At the moment
 // this only contains a copy of the config struct and a logger this only contains a copy of the config struct
 // and a logger, but it will grow to include a lot more as our build progresses.
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *jsonlog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	storage storage.Storage
+	cache   *cache.Cache
+	changes *changeBroker
+	jobs    *jobs.Runner
+	wg      sync.WaitGroup
 }
 
 func main() {
@@ -95,27 +167,74 @@ func main() {
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
-	// Read the SMTP server configuration settings into the config struct, using the Mailtrap settings as the
-	// default values.
-	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
+	// Read the email settings into the config struct. By default emails are written to a local mbox file, so that
+	// the application can be run without an SMTP server, but in production -smtp-mode=smtp must be set. There are
+	// deliberately no default SMTP credentials: they must be given when using -smtp-mode=smtp, ideally in the environment
+	// or the config file rather than on the command line.
+	flag.StringVar(&cfg.smtp.mode, "smtp-mode", "mbox", "How emails are sent (smtp|mbox|memory|noop)")
+	flag.StringVar(&cfg.smtp.host, "smtp-host", "localhost", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", "825552d0272d61", "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", "98718e5f18c99f", "SMTP password")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.luistmatosdev.dev>", "SMTP sender")
+	flag.StringVar(&cfg.smtp.mboxFile, "smtp-mbox-file", "./mail.mbox", "File that emails are written to with -smtp-mode=mbox")
+
+	// Process the -cors-trusted-origins flag with a fieldsFlag, which uses the strings.Fields() function to split the
+	// flag value into a slice based on whitespace characters and assign it to our config struct. Importantly, if the
+	// -cors-trusted-origins flag is not present, contains the empty string or contains only whitespaces, then
+	// strings.Fields() will return an empty []string slice.
+	flag.Var((*fieldsFlag)(&cfg.cors.trustedOrigins), "cors-trusted-origins", "Trusted CORS origins (space separated)")
+
+	// Read the trash retention settings. By default deleted movies can be restored for 30 days.
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted movies are kept before being purged")
+	flag.DurationVar(&cfg.trash.purgeInterval, "trash-purge-interval", time.Hour, "How often to purge expired deleted movies")
+
+	// Read how long idempotency keys are remembered for.
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "How long responses to requests with an Idempotency-Key are kept")
+
+	// Read the directory where uploaded files are kept.
+	flag.StringVar(&cfg.storage.localDir, "storage-local-dir", "./uploads", "Directory for uploaded files")
 
-	// Use the flag.Func() function to process the -cors-trusted-origins command line flag. In this we use the
-	// strings.Fields() function to split the flag value into a slice based on whitespace characters and assign it to
-	// our config struct. Importantly, if the -cors-trusted-origins flag is not present, contains the empty string
-	// or contains only whitespaces, then strings.Fields() will return an empty []string slice.
-	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
-		cfg.cors.trustedOrigins = strings.Fields(val)
-		return nil
-	})
+	// Read the settings for encoding responses.
+	flag.BoolVar(&cfg.responses.pretty, "responses-pretty", true, "Indent JSON and XML responses (false sends them compact)")
+	flag.BoolVar(&cfg.responses.compression, "compression-enabled", true, "Compress responses with brotli or gzip")
+	flag.IntVar(&cfg.responses.compressionMinSize, "compression-min-size", 1024, "Minimum response size in bytes to compress")
+
+	// Read how long the movie changes feed can be resumed for.
+	flag.DurationVar(&cfg.changes.retention, "changes-retention", 7*24*time.Hour, "How long movie changes are kept for resuming the changes feed")
+
+	// Read the settings for webhook deliveries.
+	flag.DurationVar(&cfg.webhooks.timeout, "webhooks-timeout", 10*time.Second, "How long webhook receivers have to respond")
+	flag.IntVar(&cfg.webhooks.maxAttempts, "webhooks-max-attempts", 10, "How many times a webhook delivery is attempted before it fails")
+	flag.DurationVar(&cfg.webhooks.retention, "webhooks-retention", 30*24*time.Hour, "How long finished webhook deliveries are kept in the delivery log")
+
+	// Read the settings for the background job queue.
+	flag.IntVar(&cfg.jobs.workers, "jobs-workers", 4, "Number of background job workers")
+	flag.DurationVar(&cfg.jobs.timeout, "jobs-timeout", time.Minute, "How long a background job can run for")
+	flag.IntVar(&cfg.jobs.maxAttempts, "jobs-max-attempts", 8, "How many times a background job is attempted before it is dead")
+	flag.DurationVar(&cfg.jobs.retention, "jobs-retention", 7*24*time.Hour, "How long background jobs which succeeded are kept")
+
+	// Read the settings for the response cache.
+	flag.BoolVar(&cfg.cache.enabled, "cache-enabled", true, "Enable the movie response cache")
+	flag.IntVar(&cfg.cache.size, "cache-size", 1000, "Maximum number of responses in the movie response cache")
+	flag.DurationVar(&cfg.cache.ttl, "cache-ttl", time.Minute, "How long responses are kept in the movie response cache")
+
+	// The -config flag holds the path of a YAML, TOML or JSON file to read the settings from.
+	flag.String("config", "", "Config file (.yaml, .yml, .toml or .json)")
 
 	// Create a new version boolean flag with the default value of false
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
-	flag.Parse()
+	// The -print-config flag shows the settings that the server would run with, and where each came from.
+	printConfig := flag.Bool("print-config", false, "Display the effective configuration (with secrets redacted) and exit")
+
+	// Instead of just parsing the command line, load the settings from all of their sources: the flag defaults, then
+	// the config file, then the GREENLIGHT_* environment variables, then the command line.
+	sources, err := settings.Load(flag.CommandLine, os.Args[1:], configOptions)
+	if err != nil {
+		printConfigErrors(os.Stderr, strings.Split(err.Error(), "\n"))
+		os.Exit(2)
+	}
 
 	// If the version flag value is true, then print out the version number and immediately exit.
 	if *displayVersion {
@@ -123,6 +242,32 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Check the whole config before using any of it.
+	v := validator.New()
+	validateConfig(v, cfg)
+
+	// If the print-config flag value is true, print out the config and exit, along with any problems with it.
+	if *printConfig {
+		err := settings.Print(os.Stdout, flag.CommandLine, sources, configOptions)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		if !v.Valid() {
+			fmt.Println()
+			printConfigErrors(os.Stdout, validationProblems(v.Errors))
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	if !v.Valid() {
+		printConfigErrors(os.Stderr, validationProblems(v.Errors))
+		os.Exit(2)
+	}
+
 	// Initialize a new jsonlog.Logger which writes any messages *at or above* the INFO severity level to the
 	// standard out stream.
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
@@ -161,14 +306,43 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	// Set up the storage backend for uploaded files. At the moment this is always the local filesystem.
+	store, err := storage.NewLocal(cfg.storage.localDir)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	// Set up the mailer with the transport picked by the -smtp-mode flag. It skips the addresses on the suppression
+	// list, and adds the ones that hard-bounce.
+	models := data.NewModels(db)
+
+	mail, err := openMailer(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	mail = mailer.WithSuppressionList(mail, models.Suppressions)
+
 	// Declare an instance of the application struct, containing the config struct and the logger.
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:  cfg,
+		logger:  logger,
+		models:  models,
+		mailer:  mail,
+		storage: store,
+		changes: newChangeBroker(),
 	}
 
+	// Set up the response cache, if it is enabled, and empty it whenever the movies are changed.
+	if cfg.cache.enabled {
+		app.cache = cache.New(cfg.cache.size, cfg.cache.ttl)
+		app.models.OnMovieChange(app.cache.Invalidate)
+	}
+
+	// Set up the background job runner, with a handler for each kind of job.
+	app.jobs = jobs.NewRunner(app.models.Jobs, logger, cfg.jobs.workers, cfg.jobs.timeout)
+	app.registerJobHandlers()
+
 	// Call app.serve() to start the server.
 	err = app.serve()
 	if err != nil {
@@ -176,6 +350,27 @@ func main() {
 	}
 }
 
+// The openMailer() function returns a Mailer which sends emails in the way picked by the -smtp-mode flag. The memory
+// mode keeps the emails in a mailer.Recorder, which is mostly useful when the application is built in tests.
+func openMailer(cfg config) (mailer.Mailer, error) {
+	var transport mailer.Transport
+
+	switch cfg.smtp.mode {
+	case "smtp":
+		transport = mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password)
+	case "mbox":
+		transport = mailer.NewMbox(cfg.smtp.mboxFile)
+	case "memory":
+		transport = mailer.NewRecorder()
+	case "noop":
+		transport = mailer.Noop{}
+	default:
+		return nil, fmt.Errorf("invalid -smtp-mode %q: must be smtp, mbox, memory or noop", cfg.smtp.mode)
+	}
+
+	return mailer.New(transport, cfg.smtp.sender), nil
+}
+
 // The openDB() function returns a sql.DB connection pool.
 func openDB(cfg config) (*sql.DB, error) {
 	// Use sql.Open() to create an empty connection pool, using the DSN from the config struct.
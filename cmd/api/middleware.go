@@ -1,14 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"expvar"
 	"fmt"
 	"github.com/felixge/httpsnoop"
+	"github.com/julienschmidt/httprouter"
 	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
+	"greenlight.luismatosgarcia.dev/internal/cache"
 	"greenlight.luismatosgarcia.dev/internal/data"
 	"greenlight.luismatosgarcia.dev/internal/validator"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -260,12 +266,15 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 					// origin as the value and break out of the loop.
 					w.Header().Set("Access-Control-Allow-Origin", origin)
 
+					// Let the client read the ETag header, so that it can make conditional requests.
+					w.Header().Set("Access-Control-Expose-Headers", "ETag")
+
 					// Check if the request has the HTTP method OPTIONS and contains the "Access-Control-Request-Method"
 					// header. If it does, then we treat it as a preflight request.
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 						// Set the necessary preflight response headers, as discussed previously.
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match, Idempotency-Key")
 
 						// Write the headers along with a 200 OK status and return from the middleware with no
 						// further action.
@@ -280,6 +289,182 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
+// The negotiate() middleware picks the response format from the request's Accept header and adds it to the request
+// context, where the writeResponse() helper reads it from. If the client doesn't accept any of the formats that we
+// support, it sends a 406 Not Acceptable response (in JSON) without calling the next handler. The exception is a
+// request for one of the formats that handlers send themselves, like a poster image, which is passed on with the
+// default JSON format for any errors.
+func (app *application) negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response format depends on the Accept header, so tell any caches about it.
+		w.Header().Add("Vary", "Accept")
+
+		format, ok := negotiateFormat(r.Header.Get("Accept"), responseFormats)
+		if !ok {
+			if _, ok := negotiateFormat(r.Header.Get("Accept"), passthroughFormats); !ok {
+				app.notAcceptableResponse(w, r)
+				return
+			}
+
+			format = responseFormats[0]
+		}
+
+		next.ServeHTTP(w, app.contextSetResponseFormat(r, format))
+	})
+}
+
+// The compress() middleware compresses responses with brotli or gzip, depending on the request's Accept-Encoding
+// header. Small responses aren't worth the overhead, so only those of at least the configured minimum size are
+// compressed. We use httpsnoop.Wrap() to intercept the writes, so that the wrapped http.ResponseWriter still has the
+// same optional interfaces (like http.Flusher) as the original, and the metrics middleware still sees the status code
+// and the number of bytes actually sent.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.responses.compression {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The response encoding depends on the Accept-Encoding header, so tell any caches about it.
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding, ok := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if !ok || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{w: w, encoding: encoding, minSize: app.config.responses.compressionMinSize}
+
+		// Make sure that any buffered or compressed data is written out once the handler has returned. This middleware
+		// comes before recoverPanic() in the chain, so the error responses that it sends are compressed too.
+		defer func() {
+			err := cw.close()
+			if err != nil {
+				app.logError(r, err)
+			}
+		}()
+
+		next.ServeHTTP(httpsnoop.Wrap(w, httpsnoop.Hooks{
+			Write: func(httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return cw.Write
+			},
+			WriteHeader: func(httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return cw.writeHeader
+			},
+			Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+				return func() {
+					cw.flush(next)
+				}
+			},
+			ReadFrom: func(httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+				return func(src io.Reader) (int64, error) {
+					return io.Copy(cw, src)
+				}
+			},
+		}), r)
+	})
+}
+
+// The cacheResponse() middleware serves GET requests from the in-memory response cache, and adds successful responses
+// to it. The cache key includes the path and query string, the negotiated response format, the languages from the
+// Accept-Language header and the ID of the authenticated user. Including the user means that responses, which vary on
+// the Authorization header, are never shared between users (and so between permission contexts). The cache is emptied
+// by the models whenever movies change. Every response gets a Cache-Control header, telling the client how long it
+// may reuse the response for, and an Age header with the number of seconds that it has been cached for.
+func (app *application) cacheResponse(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.cache == nil || r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := strings.Join([]string{
+			r.URL.Path + "?" + r.URL.Query().Encode(),
+			app.contextGetResponseFormat(r).mediaType,
+			strings.Join(parseAcceptLanguage(r.Header.Get("Accept-Language")), ","),
+			strconv.FormatInt(app.contextGetUser(r).ID, 10),
+		}, "\n")
+
+		if cached, ok := app.cache.Get(key); ok {
+			for name, value := range cached.Header {
+				w.Header()[name] = value
+			}
+			w.Header().Set("Age", strconv.Itoa(int(time.Since(cached.StoredAt).Seconds())))
+
+			// The cached response might be the version of the movie that the client already has.
+			if inm := r.Header.Get("If-None-Match"); inm != "" && app.matchETag(inm, cached.Header.Get("ETag")) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			w.WriteHeader(cached.Status)
+			w.Write(cached.Body)
+			return
+		}
+
+		// Read the generation before running the handler, so that the response isn't stored if the movies change
+		// while it is being generated.
+		generation := app.cache.Generation()
+
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(app.cache.TTL().Seconds())))
+		w.Header().Set("Age", "0")
+
+		// Run the request while capturing a copy of the response, in the same way as the idempotent() middleware.
+		status := http.StatusOK
+		var buf bytes.Buffer
+
+		ww := httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					status = code
+					next(code)
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					buf.Write(b)
+					return next(b)
+				}
+			},
+		})
+
+		next.ServeHTTP(ww, r)
+
+		// Only cache successful responses, and leave out any which are too big to be worth keeping in memory.
+		if status != http.StatusOK || buf.Len() > 1_048_576 {
+			return
+		}
+
+		header := recordedHeader(w)
+		header.Del("Age")
+
+		app.cache.Set(key, &cache.Response{
+			Status:   status,
+			Header:   header,
+			Body:     buf.Bytes(),
+			StoredAt: time.Now(),
+		}, generation)
+	}
+}
+
+// The recordedHeader() function returns a copy of the response headers, for storing alongside a captured copy of the
+// body to replay later. The headers set by the compress() middleware describe the compressed body that was sent, rather
+// than the body that was captured, so they are left out, and the ETag that it weakened is made strong again. They are
+// set again (if the client accepts the encoding) when the stored response is replayed. None of our handlers send weak
+// ETags themselves, so any W/ prefix must have come from compress().
+func recordedHeader(w http.ResponseWriter) http.Header {
+	header := w.Header().Clone()
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+
+	if etag := header.Get("ETag"); strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", strings.TrimPrefix(etag, "W/"))
+	}
+
+	return header
+}
+
 func (app *application) metrics(next http.Handler) http.Handler {
 	// Initialize the new expvar variables when the middleware chain is first built.
 	totalRequestsReceived := expvar.NewInt("total_requests_received")
@@ -308,3 +493,148 @@ func (app *application) metrics(next http.Handler) http.Handler {
 		totalResponsesSentByStatus.Add(strconv.Itoa(metrics.Code), 1)
 	})
 }
+
+// The staticSegments() middleware works around a limitation of httprouter, which doesn't allow a static path segment
+// and a named parameter to be registered in the same position (so "/v1/movies/export" and "/v1/movies/:id" can't
+// both be routes). Instead, we register the parameterized route only and use this middleware to dispatch requests
+// to the handler for a static segment if the parameter matches its name. Any other value is passed on to next.
+func (app *application) staticSegments(param string, handlers map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if handler, ok := handlers[params.ByName(param)]; ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// The idempotent() middleware makes a POST endpoint safe to retry. If the client sends an Idempotency-Key header, the
+// first request with that key is processed as normal and its response is stored. Any retry with the same key (from
+// the same user) within the configured window gets the stored response replayed, instead of the request being run a
+// second time. Reusing a key for a different request body results in a 422 Unprocessable Entity response. Requests
+// without the header are passed straight through.
+//
+// Keys are scoped to the authenticated user. Anonymous clients (such as those registering a new account) share a
+// namespace of their own, in which each key is stored together with the request fingerprint. That way a client can
+// only be replayed the response to a request with exactly the same body as its own, which it must have sent itself,
+// and two clients who happen to pick the same key don't collide.
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		v := validator.New()
+
+		if data.ValidateIdempotencyKey(v, key); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		// Read the request body so that we can fingerprint it, using the same 1MB limit as readJSON(), and then
+		// replace it so that the next handler can read it as usual.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// The fingerprint covers the method and path as well as the body, so that a key can't be reused across
+		// endpoints.
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+		hash.Write(body)
+		fingerprint := hash.Sum(nil)
+
+		user := app.contextGetUser(r)
+
+		// The anonymous user has an ID of 0, which no real user has, so their keys are kept apart from everyone
+		// else's.
+		if user.IsAnonymous() {
+			key += ":" + hex.EncodeToString(fingerprint)
+		}
+
+		existing, err := app.models.Idempotency.Claim(key, user.ID, fingerprint, app.config.idempotency.ttl)
+		if err != nil && !errors.Is(err, data.ErrEditConflict) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		switch {
+		case existing != nil && !bytes.Equal(existing.Fingerprint, fingerprint):
+			app.idempotencyKeyReusedResponse(w, r)
+			return
+
+		case errors.Is(err, data.ErrEditConflict), existing != nil && !existing.Completed:
+			app.idempotencyKeyInProgressResponse(w, r)
+			return
+
+		case existing != nil:
+			// Replay the stored response, adding a header so that the client can tell that it is a replay.
+			for key, value := range existing.Headers {
+				w.Header()[key] = value
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(existing.Status)
+			w.Write(existing.Body)
+			return
+		}
+
+		// We've claimed the key, so run the request while capturing a copy of the response.
+		recorded := &data.IdempotentRequest{Key: key, UserID: user.ID, Status: http.StatusOK}
+		var buf bytes.Buffer
+
+		ww := httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					recorded.Status = code
+					next(code)
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					buf.Write(b)
+					return next(b)
+				}
+			},
+		})
+
+		// If the handler panics, or fails with a server error, release the key rather than storing the response, so
+		// that the client can retry the request. After releasing the key we panic again, so that the recoverPanic()
+		// middleware still sends the 500 Internal Server Error response.
+		release := func() {
+			err := app.models.Idempotency.Release(key, user.ID)
+			if err != nil {
+				app.logError(r, err)
+			}
+		}
+
+		defer func() {
+			if err := recover(); err != nil {
+				release()
+				panic(err)
+			}
+		}()
+
+		next.ServeHTTP(ww, r)
+
+		if recorded.Status >= http.StatusInternalServerError {
+			release()
+			return
+		}
+
+		recorded.Headers = recordedHeader(w)
+		recorded.Body = buf.Bytes()
+
+		err = app.models.Idempotency.Complete(recorded)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+}
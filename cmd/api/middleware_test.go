@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"greenlight.luismatosgarcia.dev/internal/data"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// idempotencyStore is a database/sql connector standing in for Postgres in the idempotency tests. It answers the
+// queries made by IdempotencyModel, keeping the stored requests in a map. Keys never expire.
+type idempotencyStore struct {
+	mu       sync.Mutex
+	requests map[string]*idempotencyRow
+}
+
+type idempotencyRow struct {
+	fingerprint []byte
+	status      driver.Value
+	headers     []byte
+	body        []byte
+}
+
+func newIdempotencyStore() *idempotencyStore {
+	return &idempotencyStore{requests: make(map[string]*idempotencyRow)}
+}
+
+func (s *idempotencyStore) Connect(context.Context) (driver.Conn, error) {
+	return idempotencyConn{s}, nil
+}
+func (s *idempotencyStore) Driver() driver.Driver { return nil }
+
+// The keys() method returns the stored keys, each prefixed with its user ID.
+func (s *idempotencyStore) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.requests))
+	for key := range s.requests {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+type idempotencyConn struct{ store *idempotencyStore }
+
+func (c idempotencyConn) Prepare(string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("not supported")
+}
+func (c idempotencyConn) Close() error              { return nil }
+func (c idempotencyConn) Begin() (driver.Tx, error) { return nil, fmt.Errorf("not supported") }
+
+func rowKey(key, userID driver.Value) string {
+	return fmt.Sprintf("%d/%s", userID, key)
+}
+
+func (c idempotencyConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	k := rowKey(args[0].Value, args[1].Value)
+
+	switch {
+	case strings.Contains(query, "INSERT INTO idempotency_keys"):
+		if _, ok := c.store.requests[k]; ok {
+			return &singleRow{columns: []string{"key"}, done: true}, nil
+		}
+
+		c.store.requests[k] = &idempotencyRow{fingerprint: args[2].Value.([]byte)}
+
+		return &singleRow{columns: []string{"key"}, values: []driver.Value{args[0].Value}}, nil
+
+	case strings.Contains(query, "SELECT fingerprint"):
+		row, ok := c.store.requests[k]
+		if !ok {
+			return &singleRow{columns: []string{"fingerprint", "status", "headers", "body"}, done: true}, nil
+		}
+
+		return &singleRow{
+			columns: []string{"fingerprint", "status", "headers", "body"},
+			values:  []driver.Value{row.fingerprint, row.status, row.headers, row.body},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected query: %s", query)
+}
+
+func (c idempotencyConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	switch {
+	case strings.Contains(query, "UPDATE idempotency_keys"):
+		row := c.store.requests[rowKey(args[3].Value, args[4].Value)]
+		row.status = args[0].Value
+		row.headers = args[1].Value.([]byte)
+		row.body = args[2].Value.([]byte)
+
+	case strings.Contains(query, "DELETE FROM idempotency_keys"):
+		delete(c.store.requests, rowKey(args[0].Value, args[1].Value))
+
+	default:
+		return nil, fmt.Errorf("unexpected query: %s", query)
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+// TestIdempotentAnonymous checks that anonymous requests with an Idempotency-Key are only replayed to a request with
+// the same body, and are stored in their own namespace rather than under the bare key.
+func TestIdempotentAnonymous(t *testing.T) {
+	store := newIdempotencyStore()
+
+	db := sql.OpenDB(store)
+	defer db.Close()
+
+	app := newTestApplication(t)
+	app.config.idempotency.ttl = 24 * time.Hour
+	app.models.Idempotency = data.IdempotencyModel{DB: db}
+
+	calls := 0
+
+	handler := app.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		body, _ := io.ReadAll(r.Body)
+
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "call %d: %s", calls, body)
+	})
+
+	send := func(user *data.User, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/users", strings.NewReader(body))
+		r.Header.Set("Idempotency-Key", "shared-key")
+		r = app.contextSetUser(r, user)
+
+		rr := httptest.NewRecorder()
+		handler(rr, r)
+
+		return rr
+	}
+
+	alice := `{"email": "alice@example.com"}`
+	bob := `{"email": "bob@example.com"}`
+
+	first := send(data.AnonymousUser, alice)
+	if first.Code != http.StatusAccepted || first.Body.String() != "call 1: "+alice {
+		t.Fatalf("first request: got %d %q", first.Code, first.Body.String())
+	}
+
+	// A retry of the same request is replayed rather than run again.
+	retry := send(data.AnonymousUser, alice)
+	if retry.Body.String() != first.Body.String() || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry: got %q (replayed %q); want a replay of the first response", retry.Body.String(), retry.Header().Get("Idempotent-Replayed"))
+	}
+
+	// Another client with the same key but a different request is run as normal, rather than being rejected or
+	// replayed the first client's response.
+	other := send(data.AnonymousUser, bob)
+	if other.Code != http.StatusAccepted || other.Body.String() != "call 2: "+bob {
+		t.Errorf("other client: got %d %q; want its own response", other.Code, other.Body.String())
+	}
+
+	// An authenticated user with the same key isn't replayed the anonymous response either.
+	user := send(&data.User{ID: 7}, alice)
+	if user.Body.String() != "call 3: "+alice {
+		t.Errorf("authenticated user: got %q; want its own response", user.Body.String())
+	}
+
+	for _, key := range store.keys() {
+		if key == "0/shared-key" {
+			t.Errorf("anonymous request was stored under the bare key")
+		}
+	}
+
+	if calls != 3 {
+		t.Errorf("handler called %d times; want 3", calls)
+	}
+}
+
+// TestRecordedHeaderETag checks that a response recorded after compress() has weakened its ETag is stored with the
+// strong tag, and that compress() only weakens it again when the replay is compressed.
+func TestRecordedHeaderETag(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.responses.compression = true
+	app.config.responses.compressionMinSize = 0
+
+	body := strings.Repeat(`{"movie": {"title": "Moana"}}`, 100)
+
+	var recorded http.Header
+
+	original := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"1-0123456789abcdef"`)
+		w.Write([]byte(body))
+
+		recorded = recordedHeader(w)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	rr := httptest.NewRecorder()
+	original.ServeHTTP(rr, r)
+
+	if got := rr.Header().Get("ETag"); got != `W/"1-0123456789abcdef"` {
+		t.Fatalf("compressed response: got ETag %q; want it weakened", got)
+	}
+
+	if got := recorded.Get("ETag"); got != `"1-0123456789abcdef"` {
+		t.Errorf("recorded ETag %q; want the strong tag", got)
+	}
+
+	if recorded.Get("Content-Encoding") != "" {
+		t.Errorf("recorded Content-Encoding %q; want none", recorded.Get("Content-Encoding"))
+	}
+
+	replay := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for key, value := range recorded {
+			w.Header()[key] = value
+		}
+		w.Write([]byte(body))
+	}))
+
+	tests := []struct {
+		acceptEncoding string
+		etag           string
+	}{
+		{"", `"1-0123456789abcdef"`},
+		{"gzip", `W/"1-0123456789abcdef"`},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil)
+		if tt.acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		}
+
+		rr := httptest.NewRecorder()
+		replay.ServeHTTP(rr, r)
+
+		if got := rr.Header().Get("ETag"); got != tt.etag {
+			t.Errorf("replay with Accept-Encoding %q: got ETag %q; want %q", tt.acceptEncoding, got, tt.etag)
+		}
+	}
+}
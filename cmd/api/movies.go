@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"greenlight.luismatosgarcia.dev/internal/data"
 	"greenlight.luismatosgarcia.dev/internal/validator"
+	"mime"
 	"net/http"
 )
 
@@ -40,18 +41,25 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Genres:  input.Genres,
 	}
 
+	genres, err := app.models.Genres.Catalogue()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	// Initialize a new validator instance.
 	v := validator.New()
 
 	// Call the ValidateMovie() function and return a response containing the errors if any of the checks fail.
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	// Call the Insert() method on our movies model, passing in a pointer to the validated movie struct.
-	// This will create a record in the database and update the movie struct with the system-generated information.
-	err = app.models.Movies.Insert(movie)
+	// Call the Insert() method on our movies model, passing in a pointer to the validated movie struct and the ID of
+	// the current user. This will create a record in the database and update the movie struct with the
+	// system-generated information.
+	err = app.models.Movies.Insert(movie, app.contextGetUser(r).ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -62,10 +70,11 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	// a new Location header, interpolating the system-generated ID four our new movie in the URL.
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", app.etag(r, movie.Version, movie))
 
 	// Write a JSON response with a 201 Created status code, the movie data in the response body, and the
 	// Location header.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -83,10 +92,34 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Read the optional fields query string parameter, which lets the client ask for a subset of the movie fields,
+	// and check that it only contains fields that we know about.
+	fields := app.readCSV(r.URL.Query(), "fields", []string{})
+
+	v := validator.New()
+
+	// Read the languages that the client would like the title and genre names in.
+	languages := app.readLanguages(r, v)
+
+	// Read the format that the client would like the runtime in.
+	format := app.readRuntimeFormat(r.URL.Query(), v)
+
+	if data.ValidateFields(v, fields, data.MovieFieldSafeList); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// We always need the version to generate the ETag header, so add it to the columns that we select if the client
+	// asked for specific fields which don't include it. The projection below still only contains the requested fields.
+	columns := fields
+	if len(fields) > 0 && !validator.PermittedValue("version", fields...) {
+		columns = append(fields[:len(fields):len(fields)], "version")
+	}
+
 	// Call the Get() method to fetch the data for a specific movie. We also need to use the errors.Is() function
 	// to check if it returns a data.ErrRecordNotFound error, in which case we send a 404 Not Found response to
 	// the client.
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(id, columns...)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -96,8 +129,33 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+
+	err = app.models.Translations.Localize([]*data.Movie{movie}, languages)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Keep only the requested fields.
+	projection := movie.Project(fields, format)
+
+	// Set the ETag header from the movie version and the representation. If the client already has this
+	// representation of the movie (because it sent a matching If-None-Match header), then send a 304 Not Modified
+	// response with no body. The representation depends on the Accept-Language header, so we add it to the Vary header
+	// for any caches along the way. We add it directly, as writeResponse() would replace the Vary values set by our
+	// middleware.
+	headers := make(http.Header)
+	headers.Set("ETag", app.etag(r, movie.Version, projection))
+	w.Header().Add("Vary", "Accept-Language")
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" && app.matchETag(inm, headers.Get("ETag")) {
+		w.Header().Set("ETag", headers.Get("ETag"))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	// Encode the struct to JSON and send it the HTTP response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": projection}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -124,56 +182,64 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Declare an input struct  to hold the expected ata from the client.
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
-	}
-
-	// Read the JSON request body data into the input struct.
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
+	// If the client sent an If-Match header, only carry on if it matches the version of the movie that we just read.
+	// As the Update() call below only succeeds if the version is still the same, this guarantees that the client's
+	// changes are applied to exactly the version it asked for.
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch != "" && !app.matchVersion(ifMatch, movie.Version) {
+		app.preconditionFailedResponse(w, r)
 		return
 	}
 
-	// If the input.Title value is nil then we know that no corresponding "title" key/value pair was provided
-	// in the JSON request body. So we move on and leave the movie record unchanged. Otherwise, we update
-	// the movie record with the new title value. Importantly, because input.Title is now a pointer to a string,
-	// we need to dereference the pointer using the * operator to get the underlying value before assigning it to our
-	// movie record.
-	if input.Title != nil {
-		movie.Title = *input.Title
-	}
+	v := validator.New()
 
-	// We also do the same for the other fields in the input struct.
-	if input.Year != nil {
-		movie.Year = *input.Year
+	// Apply the changes in the request body to the movie. The Content-Type header tells us which format the client
+	// used: a plain JSON object containing the fields to change, a JSON Merge Patch (RFC 7396) or a JSON Patch
+	// (RFC 6902). If there is no Content-Type header we treat the body as plain JSON, as we always have.
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "", "application/json":
+		err = app.readMovieChanges(w, r, movie)
+	case "application/merge-patch+json", "application/json-patch+json":
+		err = app.readMoviePatch(w, r, mediaType, movie, v)
+	default:
+		app.unsupportedMediaTypeResponse(w, r)
+		return
 	}
 
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
 	}
 
-	if input.Genres != nil {
-		movie.Genres = input.Genres // Note that we don't need to dereference a slice.
+	// Validate the updated movie record, sending the client a 422 Unprocessable Entity response if
+	// any checks fail (including a patch which couldn't be applied).
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
-	// Validate the updated movie record, sending the client a 422 Unprocessable Entity response if
-	// any checks fail.
-	v := validator.New()
+	genres, err := app.models.Genres.Catalogue()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	// Pass the updated movie record to our new Update() method.
-	err = app.models.Movies.Update(movie)
+	// Pass the updated movie record to our new Update() method, along with the ID of the user making the change so
+	// that it is recorded in the movie's revision history.
+	err = app.models.Movies.Update(movie, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
+		// If the client made the update conditional with If-Match, then a conflict means that its precondition
+		// no longer holds.
+		case errors.Is(err, data.ErrEditConflict) && ifMatch != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -182,27 +248,108 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Include the ETag for the new version of the movie in the response.
+	headers := make(http.Header)
+	headers.Set("ETag", app.etag(r, movie.Version, movie))
+
 	// Write the updated movie record ina JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// The movieChanges type holds the fields of a movie that a client wants to change. The fields are pointers (and the
+// genres a slice) so that we can tell which of them were actually provided.
+type movieChanges struct {
+	Title   *string       `json:"title"`
+	Year    *int32        `json:"year"`
+	Runtime *data.Runtime `json:"runtime"`
+	Genres  []string      `json:"genres"`
+}
+
+// The apply() method copies the provided fields onto the movie, leaving the others unchanged.
+func (c movieChanges) apply(movie *data.Movie) {
+	// If the c.Title value is nil then we know that no corresponding "title" key/value pair was provided
+	// in the JSON. So we move on and leave the movie record unchanged. Otherwise, we update the movie record with the
+	// new title value. Importantly, because c.Title is a pointer to a string, we need to dereference the pointer
+	// using the * operator to get the underlying value before assigning it to our movie record.
+	if c.Title != nil {
+		movie.Title = *c.Title
+	}
+
+	// We also do the same for the other fields.
+	if c.Year != nil {
+		movie.Year = *c.Year
+	}
+
+	if c.Runtime != nil {
+		movie.Runtime = *c.Runtime
+	}
+
+	if c.Genres != nil {
+		movie.Genres = c.Genres // Note that we don't need to dereference a slice.
+	}
+}
+
+// The readMovieChanges() helper reads a plain JSON object from the request body and copies the fields that it
+// contains onto the movie. Fields which aren't in the body are left unchanged.
+func (app *application) readMovieChanges(w http.ResponseWriter, r *http.Request, movie *data.Movie) error {
+	// Read the JSON request body data into the input struct.
+	var input movieChanges
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		return err
+	}
+
+	input.apply(movie)
+
+	return nil
+}
+
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract the movie ID from the URL.
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
+		return
+	}
+
+	// If the client sent an If-Match header, read the current version of the movie and check that it matches. The
+	// version is then passed to Delete(), so the movie is only deleted if it hasn't changed in the meantime.
+	var version int32
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch != "" {
+		movie, err := app.models.Movies.Get(id, "id", "version")
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !app.matchVersion(ifMatch, movie.Version) {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+
+		version = movie.Version
 	}
 
 	// Delete the movie from the database, sending a 404 Not Found response to the client if there isn't
 	// a matching record.
-	err = app.models.Movies.Delete(id)
+	err = app.models.Movies.Delete(id, version)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -210,7 +357,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Return a 200 OK status code along with a success message
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -220,8 +367,10 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// To keep things consistent with our other handlers, we'll define an input struct to hold the expected values
 	// from the request query string.
 	var input struct {
-		Title  string
-		Genres []string
+		Title       string
+		Genres      []string
+		PersonID    int64
+		InWatchlist bool
 		data.Filters
 	}
 
@@ -236,6 +385,13 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
 
+	// The person_id filter limits the list to the movies which credit a specific person.
+	input.PersonID = int64(app.readInt(qs, "person_id", 0, v))
+	v.Check(input.PersonID >= 0, "person_id", "must be a positive integer")
+
+	// The in_watchlist filter limits the list to the movies on the authenticated user's watchlist.
+	input.InWatchlist = app.readBool(qs, "in_watchlist", false, v)
+
 	// Ge the page and page_size query string values as integers. Notice that we set the default value to 1
 	// ad default page_size to 20, and that we pass the validator instance as the final argument here.
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
@@ -245,7 +401,19 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// (which will imply a ascending sort on movie ID).
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 	// Add the supported values for this endpoint to the sort safelist.
-	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafeList = []string{
+		"id", "title", "year", "runtime", "average_rating", "rating_count",
+		"-id", "-title", "-year", "-runtime", "-average_rating", "-rating_count",
+	}
+
+	// Extract the fields query string value, which limits the movie fields included in the response, and use the
+	// movie field safelist to check it.
+	input.Filters.Fields = app.readCSV(qs, "fields", []string{})
+	input.Filters.FieldSafeList = data.MovieFieldSafeList
+
+	// Read the languages that the client would like the titles and genre names in, and the runtime format.
+	languages := app.readLanguages(r, v)
+	format := app.readRuntimeFormat(qs, v)
 
 	//Execute the validation checks on the Filters struct and send a response containing the errors if necessary.
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
@@ -253,15 +421,109 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	var watchlistUserID int64
+	if input.InWatchlist {
+		watchlistUserID = app.contextGetUser(r).ID
+	}
+
+	// We need the movie IDs to look up their localized titles, so select the id column even if the client didn't ask
+	// for it. The projection below still only contains the requested fields.
+	filters := input.Filters
+	if len(languages) > 0 && len(filters.Fields) > 0 && !validator.PermittedValue("id", filters.Fields...) {
+		filters.Fields = append(filters.Fields[:len(filters.Fields):len(filters.Fields)], "id")
+	}
+
 	// Call the GetAll() method to retrieve the movies, passing in the various filter parameters.
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.PersonID, watchlistUserID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Translations.Localize(movies, languages)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Send a JSON response containing the movie data.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	// Keep only the requested fields in each movie before sending the JSON response.
+	movieList := data.ProjectMovies(movies, input.Filters.Fields, format)
+
+	w.Header().Add("Vary", "Accept-Language")
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movies": movieList, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listDeletedMoviesHandler handles the "GET /v1/movies/trash" endpoint, returning a page of the movies which have
+// been deleted but not yet purged. By default the most recently deleted movies are listed first.
+func (app *application) listDeletedMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.SortSafeList = []string{"id", "title", "deleted_at", "-id", "-title", "-deleted_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetAllDeleted(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The restoreMovieHandler handles the "POST /v1/movies/:id/restore" endpoint, taking a movie back out of the trash.
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Restore the movie, sending a 404 Not Found response if there isn't a matching movie in the trash.
+	err = app.models.Movies.Restore(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Fetch the restored movie so that we can send it back to the client.
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
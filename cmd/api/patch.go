@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight.luismatosgarcia.dev/internal/data"
+	"greenlight.luismatosgarcia.dev/internal/patch"
+	"greenlight.luismatosgarcia.dev/internal/validator"
+	"net/http"
+	"strings"
+)
+
+// The patchableMovie type is the JSON document that merge patches and JSON patches are applied to. It only contains
+// the fields of a movie that a client is allowed to change.
+type patchableMovie struct {
+	Title   string       `json:"title"`
+	Year    int32        `json:"year,omitempty"`
+	Runtime data.Runtime `json:"runtime,omitempty"`
+	Genres  []string     `json:"genres,omitempty"`
+}
+
+// The readMoviePatch() helper reads a JSON Merge Patch (application/merge-patch+json) or a JSON Patch
+// (application/json-patch+json) from the request body and applies it to the movie. Problems with the body itself are
+// returned as an error, while a patch which can't be applied (or which produces a document that isn't a valid movie)
+// is recorded in the validator under the "patch" key, with a message explaining exactly what went wrong.
+func (app *application) readMoviePatch(w http.ResponseWriter, r *http.Request, mediaType string, movie *data.Movie, v *validator.Validator) error {
+	doc, err := json.Marshal(patchableMovie{
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
+	})
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+
+	switch mediaType {
+	case "application/merge-patch+json":
+		var mergePatch json.RawMessage
+
+		err = app.readJSON(w, r, &mergePatch)
+		if err != nil {
+			return err
+		}
+
+		patched, err = patch.Merge(doc, mergePatch)
+
+	default:
+		var ops []patch.Operation
+
+		err = app.readJSON(w, r, &ops)
+		if err != nil {
+			return err
+		}
+
+		patched, err = patch.Apply(doc, ops)
+	}
+
+	if err != nil {
+		var patchError *patch.Error
+
+		switch {
+		case errors.As(err, &patchError):
+			v.AddError("patch", patchError.Error())
+			return nil
+		default:
+			return err
+		}
+	}
+
+	// Decode the patched document back into a movie. The patch may have added members that a movie doesn't have, or
+	// changed a value to the wrong type, so we check for both and report them against the patch.
+	var result patchableMovie
+
+	dec := json.NewDecoder(strings.NewReader(string(patched)))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&result)
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+
+		switch {
+		case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+			v.AddError("patch", fmt.Sprintf("result contains incorrect JSON type for field %q", unmarshalTypeError.Field))
+		case strings.HasPrefix(err.Error(), "json: unknown field"):
+			v.AddError("patch", "result contains unknown key"+strings.TrimPrefix(err.Error(), "json: unknown field"))
+		case errors.Is(err, data.ErrInvalidRuntimeFormat):
+			v.AddError("patch", "result contains an "+err.Error())
+		default:
+			v.AddError("patch", "result must be a JSON object")
+		}
+		return nil
+	}
+
+	movie.Title = result.Title
+	movie.Year = result.Year
+	movie.Runtime = result.Runtime
+	movie.Genres = result.Genres
+
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"greenlight.luismatosgarcia.dev/internal/data"
+	"greenlight.luismatosgarcia.dev/internal/imaging"
+	"greenlight.luismatosgarcia.dev/internal/storage"
+	"greenlight.luismatosgarcia.dev/internal/validator"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+)
+
+const (
+	// posterMaxBytes is the maximum size of a poster upload, including the rest of the multipart body.
+	posterMaxBytes = 10 << 20
+
+	// posterMinDimension and posterMaxDimension are the limits on the width and height of an uploaded poster. The
+	// maximum keeps the memory needed to decode the image (4 bytes per pixel) within reason.
+	posterMinDimension = 100
+	posterMaxDimension = 4000
+)
+
+// posterFormats maps the content types that we accept for posters to the image format names used by the image
+// package.
+var posterFormats = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// The uploadPosterHandler handles the "PUT /v1/movies/:id/poster" endpoint. It accepts a multipart/form-data body with
+// the image in a field called "poster". The image is checked and saved as the original, and then a thumbnail is
+// generated for each of the sizes in data.PosterSizes.
+func (app *application) uploadPosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id, "id")
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, posterMaxBytes)
+
+	err = r.ParseMultipartForm(posterMaxBytes)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.Is(err, http.ErrNotMultipart):
+			app.unsupportedMediaTypeResponse(w, r)
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	v := validator.New()
+
+	file, _, err := r.FormFile("poster")
+	if err != nil {
+		switch {
+		case errors.Is(err, http.ErrMissingFile):
+			v.AddError("poster", "must be provided")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	upload, err := io.ReadAll(file)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Check the content type by sniffing the data itself, rather than trusting the Content-Type that the client sent
+	// for the part.
+	format, ok := posterFormats[http.DetectContentType(upload)]
+	if !ok {
+		v.AddError("poster", "must be a JPEG, PNG or GIF image")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Read just the image header first, so that we can check the dimensions before decoding the whole image.
+	config, _, err := image.DecodeConfig(bytes.NewReader(upload))
+	if err != nil {
+		v.AddError("poster", "must be a valid image")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	v.Check(config.Width >= posterMinDimension && config.Height >= posterMinDimension, "poster",
+		fmt.Sprintf("must be at least %d pixels wide and high", posterMinDimension))
+	v.Check(config.Width <= posterMaxDimension && config.Height <= posterMaxDimension, "poster",
+		fmt.Sprintf("must not be more than %d pixels wide or high", posterMaxDimension))
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(upload))
+	if err != nil {
+		v.AddError("poster", "must be a valid image")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.storage.Put(posterKey(id, "original"), bytes.NewReader(upload))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	rgba := imaging.ToRGBA(img)
+
+	for size, width := range data.PosterSizes {
+		var buf bytes.Buffer
+
+		err = encodeThumbnail(&buf, imaging.Fit(rgba, width), format)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.storage.Put(posterKey(id, size), &buf)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.models.Movies.SetPoster(id, format)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showPosterHandler handles the "GET /v1/movies/:id/poster" endpoint. The size query string parameter picks one
+// of the thumbnails, and without it the original image is returned.
+func (app *application) showPosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	size := app.readString(r.URL.Query(), "size", "original")
+
+	_, ok := data.PosterSizes[size]
+	if v.Check(ok || size == "original", "size", "must be original, small, medium or large"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	format, updatedAt, err := app.models.Movies.GetPoster(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	file, err := app.storage.Open(posterKey(id, size))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	if size != "original" {
+		format = thumbnailFormat(format)
+	}
+
+	w.Header().Set("Content-Type", "image/"+format)
+
+	// If the storage backend gives us something we can seek in, let http.ServeContent() take care of conditional
+	// and range requests for us. Otherwise just copy the file to the response.
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", updatedAt, seeker)
+		return
+	}
+
+	_, err = io.Copy(w, file)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
+
+// The posterKey() helper returns the storage key for one size of a movie's poster.
+func posterKey(id int64, size string) string {
+	return fmt.Sprintf("posters/%d/%s", id, size)
+}
+
+// The thumbnailFormat() helper returns the format that thumbnails are saved in for a poster of the given format. JPEG
+// posters get JPEG thumbnails, while PNG and GIF posters get PNG thumbnails so that any transparency is kept.
+func thumbnailFormat(format string) string {
+	if format == "jpeg" {
+		return "jpeg"
+	}
+	return "png"
+}
+
+// The encodeThumbnail() helper writes the thumbnail to w in the right format for the original poster.
+func encodeThumbnail(w io.Writer, img image.Image, format string) error {
+	switch thumbnailFormat(format) {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	default:
+		return png.Encode(w, img)
+	}
+}
@@ -0,0 +1,602 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"github.com/vmihailenco/msgpack/v5"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A responseFormat is one of the representations that we can send responses in. The encode function turns an
+// envelope into the response body, indenting it for readability if pretty is true and the format supports it.
+type responseFormat struct {
+	mediaType string
+	aliases   []string
+	encode    func(data envelope, pretty bool) ([]byte, error)
+}
+
+// The responseFormats slice holds the formats that we support, in our order of preference. JSON comes first, so that
+// it is used whenever the client doesn't mind which format it gets.
+var responseFormats = []*responseFormat{
+	{mediaType: "application/json", encode: encodeJSON},
+	{mediaType: "application/xml", aliases: []string{"text/xml"}, encode: encodeXML},
+	{mediaType: "text/csv", encode: encodeCSV},
+	{mediaType: "application/msgpack", aliases: []string{"application/x-msgpack", "application/vnd.msgpack"}, encode: encodeMsgpack},
+}
+
+// The passthroughFormats slice holds the formats which some endpoints send without using writeResponse(), like the
+// movie exports, the poster images, the changes feed and the email previews. The negotiate() middleware lets requests
+// for them through to the handler, rather than sending a 406 Not Acceptable response.
+var passthroughFormats = []*responseFormat{
+	{mediaType: "application/x-ndjson"},
+	{mediaType: "image/jpeg", aliases: []string{"image/png", "image/gif"}},
+	{mediaType: "text/event-stream"},
+	{mediaType: "text/html"},
+}
+
+// Convert the string "responseFormat" to a contextKey, which we'll use to store the format chosen by the negotiate()
+// middleware in the request context.
+const responseFormatContextKey = contextKey("responseFormat")
+
+// The contextSetResponseFormat() method returns a new copy of the request with the response format added to the
+// context.
+func (app *application) contextSetResponseFormat(r *http.Request, format *responseFormat) *http.Request {
+	ctx := context.WithValue(r.Context(), responseFormatContextKey, format)
+	return r.WithContext(ctx)
+}
+
+// The contextGetResponseFormat() method returns the response format from the request context. Unlike the user, it is
+// fine for there to be no format, as responses can be sent before the negotiate() middleware has run (for example when
+// we recover from a panic). In that case we fall back to JSON.
+func (app *application) contextGetResponseFormat(r *http.Request) *responseFormat {
+	format, ok := r.Context().Value(responseFormatContextKey).(*responseFormat)
+	if !ok {
+		return responseFormats[0]
+	}
+
+	return format
+}
+
+// The negotiateFormat() function picks the response format for an Accept header value. Every format gets the quality
+// value of the most specific media range that matches it (so "text/csv" beats "text/*", which beats "*/*"), and the
+// format with the highest quality wins. Ties go to the more specific match, then to the range the client listed first,
+// and finally to the order of the formats slice. An empty header accepts anything, so it gets the first format. It
+// returns false if none of the formats are acceptable.
+func negotiateFormat(header string, formats []*responseFormat) (*responseFormat, bool) {
+	if strings.TrimSpace(header) == "" {
+		return formats[0], true
+	}
+
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+
+	var ranges []mediaRange
+
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+
+		if value, ok := params["q"]; ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+	}
+
+	var (
+		best            *responseFormat
+		bestQ           float64
+		bestSpecificity int
+		bestIndex       int
+	)
+
+	for _, format := range formats {
+		// Find the most specific range which matches this format. Its quality applies, even if it is zero.
+		specificity, index, q := -1, 0, 0.0
+
+		for i, mr := range ranges {
+			s := format.match(mr.mediaType)
+			if s > specificity {
+				specificity, index, q = s, i, mr.q
+			}
+		}
+
+		if specificity < 0 || q <= 0 {
+			continue
+		}
+
+		if best == nil || q > bestQ ||
+			(q == bestQ && specificity > bestSpecificity) ||
+			(q == bestQ && specificity == bestSpecificity && index < bestIndex) {
+			best, bestQ, bestSpecificity, bestIndex = format, q, specificity, index
+		}
+	}
+
+	return best, best != nil
+}
+
+// The match() method reports how specifically a media range matches the format: 2 for the format's media type or one
+// of its aliases, 1 for a type wildcard like "text/*", 0 for "*/*" and -1 if it doesn't match at all.
+func (f *responseFormat) match(mediaRange string) int {
+	for _, mediaType := range append([]string{f.mediaType}, f.aliases...) {
+		switch {
+		case mediaRange == mediaType:
+			return 2
+		case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+			return 1
+		case mediaRange == "*/*":
+			return 0
+		}
+	}
+
+	return -1
+}
+
+// Define a writeResponse() helper for sending responses. This takes the destination http.ResponseWriter, the request
+// (which the negotiated response format is read from), the HTTP status code to send, the data to encode, and a header
+// map containing any additional HTTP headers we want to include in the response.
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	format := app.contextGetResponseFormat(r)
+
+	// Encode the data, returning the error if there was one.
+	body, err := format.encode(data, app.config.responses.pretty)
+	if err != nil {
+		return err
+	}
+
+	// At this point, we know that we won't encounter any more errors before writing the response, so it's
+	// safe to add any headers that we want to include. We loop through the header map and add each header
+	// to the http.ResponseWriter header map. Note that it's OK if the provided header map is nil. Go doesn't
+	// throw an error if you try to range over (or generally, read from) a nil map.
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	// Add the Content-Type header for the format, then write the status code and response body.
+	contentType := format.mediaType
+	if strings.HasPrefix(contentType, "text/") {
+		contentType += "; charset=utf-8"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(body)
+
+	return nil
+}
+
+// The encodeJSON() function encodes the envelope as JSON, with a trailing newline to make it easier to view in
+// terminal applications. Pretty JSON is indented with tabs, while compact JSON is all on one line.
+func encodeJSON(data envelope, pretty bool) ([]byte, error) {
+	var js []byte
+	var err error
+
+	if pretty {
+		js, err = json.MarshalIndent(data, "", "\t")
+	} else {
+		js, err = json.Marshal(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return append(js, '\n'), nil
+}
+
+// The other formats are all generated from the JSON encoding of the envelope, so that every format contains exactly
+// the same fields, with the same names and values (including custom encodings like the movie runtime). The
+// orderedObject type holds a decoded JSON object with its keys kept in their original order, so that XML elements and
+// CSV columns come out in the same order as the JSON fields.
+type orderedObject []orderedField
+
+type orderedField struct {
+	key   string
+	value any
+}
+
+// The toTree() function encodes the envelope to JSON and decodes it again into orderedObject, []any, string,
+// json.Number, bool and nil values.
+func toTree(data envelope) (any, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	return decodeTree(dec)
+}
+
+// The decodeTree() function reads the next JSON value from the decoder, keeping the order of any object keys.
+func decodeTree(dec *json.Decoder) (any, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		object := orderedObject{}
+
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := decodeTree(dec)
+			if err != nil {
+				return nil, err
+			}
+
+			object = append(object, orderedField{key: key.(string), value: value})
+		}
+
+		// Read the closing brace.
+		_, err = dec.Token()
+		return object, err
+
+	case json.Delim('['):
+		array := []any{}
+
+		for dec.More() {
+			value, err := decodeTree(dec)
+			if err != nil {
+				return nil, err
+			}
+
+			array = append(array, value)
+		}
+
+		// Read the closing bracket.
+		_, err = dec.Token()
+		return array, err
+
+	default:
+		return token, nil
+	}
+}
+
+// xmlNameRX matches the names that we can use as XML element names as they are. Any other keys are written as an
+// <entry> element with a key attribute.
+var xmlNameRX = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// The encodeXML() function encodes the envelope as XML. The envelope becomes a <response> element, each key becomes
+// an element of the same name, and array items become <item> elements. Null values become empty elements with a
+// nil="true" attribute, to tell them apart from empty strings.
+func encodeXML(data envelope, pretty bool) ([]byte, error) {
+	tree, err := toTree(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(&buf)
+	if pretty {
+		enc.Indent("", "\t")
+	}
+
+	err = encodeXMLElement(enc, "response", tree)
+	if err != nil {
+		return nil, err
+	}
+
+	err = enc.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	buf.WriteByte('\n')
+
+	return buf.Bytes(), nil
+}
+
+// The encodeXMLElement() function writes a single value as an element with the given name.
+func encodeXMLElement(enc *xml.Encoder, name string, value any) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	if !xmlNameRX.MatchString(name) || strings.HasPrefix(strings.ToLower(name), "xml") {
+		start.Name.Local = "entry"
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "key"}, Value: name})
+	}
+
+	if value == nil {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "nil"}, Value: "true"})
+	}
+
+	err := enc.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+
+	switch value := value.(type) {
+	case orderedObject:
+		for _, field := range value {
+			err = encodeXMLElement(enc, field.key, field.value)
+			if err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range value {
+			err = encodeXMLElement(enc, "item", item)
+			if err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		err = enc.EncodeToken(xml.CharData(scalarString(value)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// The encodeCSV() function encodes the envelope as CSV, which only suits tabular data. For list endpoints, where the
+// envelope contains a single array (like "movies"), each item becomes a row, and anything else in the envelope (like
+// the pagination metadata) is left out. Otherwise an envelope containing a single object (like "movie") becomes a
+// single row, and any other envelope is written as a single row itself. That includes error responses, so that they
+// always have an "error" column. The header line contains every key found in the rows, in the order they first
+// appear. Arrays of plain values (like the genres) are joined with commas, and any other nested values are written as
+// JSON.
+func encodeCSV(data envelope, _ bool) ([]byte, error) {
+	tree, err := toTree(data)
+	if err != nil {
+		return nil, err
+	}
+
+	root := tree.(orderedObject)
+
+	var rows []any
+
+	var arrays, objects []orderedField
+	for _, field := range root {
+		switch field.value.(type) {
+		case []any:
+			arrays = append(arrays, field)
+		case orderedObject:
+			objects = append(objects, field)
+		}
+	}
+
+	switch {
+	case len(arrays) == 1:
+		rows = arrays[0].value.([]any)
+
+		// Wrap plain values in an object, so that the column is named after the envelope key.
+		for i, row := range rows {
+			if _, ok := row.(orderedObject); !ok {
+				rows[i] = orderedObject{{key: arrays[0].key, value: row}}
+			}
+		}
+	case len(root) == 1 && len(objects) == 1 && objects[0].key != "error":
+		rows = []any{objects[0].value}
+	default:
+		rows = []any{root}
+	}
+
+	var columns []string
+	seen := make(map[string]bool)
+
+	for _, row := range rows {
+		for _, field := range row.(orderedObject) {
+			if !seen[field.key] {
+				seen[field.key] = true
+				columns = append(columns, field.key)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+
+	err = cw.Write(columns)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		values := make(map[string]any)
+		for _, field := range row.(orderedObject) {
+			values[field.key] = field.value
+		}
+
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			record[i], err = csvCell(values[column])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = cw.Write(record)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cw.Flush()
+
+	return buf.Bytes(), cw.Error()
+}
+
+// The csvCell() function converts a value into the text of a CSV field.
+func csvCell(value any) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case []any:
+		parts := make([]string, len(value))
+
+		for i, item := range value {
+			switch item.(type) {
+			case orderedObject, []any:
+				return jsonText(value)
+			}
+			parts[i] = scalarString(item)
+		}
+
+		return strings.Join(parts, ","), nil
+	case orderedObject:
+		return jsonText(value)
+	default:
+		return scalarString(value), nil
+	}
+}
+
+// The jsonText() function writes a tree value back out as compact JSON, keeping the order of object keys.
+func jsonText(value any) (string, error) {
+	switch value := value.(type) {
+	case orderedObject:
+		parts := make([]string, len(value))
+
+		for i, field := range value {
+			key, _ := json.Marshal(field.key)
+
+			text, err := jsonText(field.value)
+			if err != nil {
+				return "", err
+			}
+
+			parts[i] = string(key) + ":" + text
+		}
+
+		return "{" + strings.Join(parts, ",") + "}", nil
+	case []any:
+		parts := make([]string, len(value))
+
+		for i, item := range value {
+			text, err := jsonText(item)
+			if err != nil {
+				return "", err
+			}
+
+			parts[i] = text
+		}
+
+		return "[" + strings.Join(parts, ",") + "]", nil
+	default:
+		js, err := json.Marshal(value)
+		return string(js), err
+	}
+}
+
+// The scalarString() function returns the text of a string, number or boolean tree value.
+func scalarString(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		return ""
+	}
+}
+
+// The encodeMsgpack() function encodes the envelope as MessagePack. Numbers which are whole are encoded as integers,
+// and all others as floats.
+func encodeMsgpack(data envelope, _ bool) ([]byte, error) {
+	tree, err := toTree(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	err = msgpack.NewEncoder(&buf).Encode(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// The EncodeMsgpack() method lets the msgpack package encode an orderedObject as a map, keeping the order of the keys.
+func (o orderedObject) EncodeMsgpack(enc *msgpack.Encoder) error {
+	err := enc.EncodeMapLen(len(o))
+	if err != nil {
+		return err
+	}
+
+	for _, field := range o {
+		err = enc.EncodeString(field.key)
+		if err != nil {
+			return err
+		}
+
+		value := field.value
+
+		// The msgpack package would encode a json.Number as a string, so convert it to a number first.
+		if number, ok := value.(json.Number); ok {
+			value = msgpackNumber(number)
+		}
+
+		if array, ok := value.([]any); ok {
+			value = msgpackArray(array)
+		}
+
+		err = enc.Encode(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The msgpackArray() function returns a copy of the array with any json.Number values converted to numbers.
+func msgpackArray(array []any) []any {
+	converted := make([]any, len(array))
+
+	for i, item := range array {
+		switch item := item.(type) {
+		case json.Number:
+			converted[i] = msgpackNumber(item)
+		case []any:
+			converted[i] = msgpackArray(item)
+		default:
+			converted[i] = item
+		}
+	}
+
+	return converted
+}
+
+// The msgpackNumber() function converts a json.Number to an int64 if it is a whole number, or a float64 otherwise.
+func msgpackNumber(number json.Number) any {
+	if i, err := number.Int64(); err == nil {
+		return i
+	}
+
+	f, err := number.Float64()
+	if err != nil {
+		return number.String()
+	}
+
+	return f
+}
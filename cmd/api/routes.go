@@ -23,20 +23,80 @@ func (app *application) routes() http.Handler {
 	// respectively.
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.cacheResponse(app.listMoviesHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.idempotent(app.createMovieHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.staticSegments("id", map[string]http.HandlerFunc{
+		"changes": app.requirePermission("movies:read", app.movieChangesHandler),
+		"export":  app.requirePermission("movies:read", app.exportMoviesHandler),
+		"trash":   app.requirePermission("movies:write", app.listDeletedMoviesHandler),
+	}, app.requirePermission("movies:read", app.cacheResponse(app.showMovieHandler))))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id", app.staticSegments("id", map[string]http.HandlerFunc{
+		"bulk":  app.requirePermission("movies:write", app.importMoviesHandler),
+		"batch": app.requirePermission("movies:write", app.batchMoviesHandler),
+	}, app.methodNotAllowedResponse))
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission("movies:write", app.restoreMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission("movies:read", app.listMovieRevisionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions/:version", app.requirePermission("movies:read", app.showMovieRevisionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revert/:version", app.requirePermission("movies:write", app.revertMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits", app.requirePermission("movies:read", app.listMovieCreditsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/credits", app.requirePermission("people:write", app.replaceMovieCreditsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/poster", app.requirePermission("movies:read", app.showPosterHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.uploadPosterHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/titles", app.requirePermission("movies:read", app.listMovieTitlesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/titles/:lang", app.requirePermission("movies:write", app.setMovieTitleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/titles/:lang", app.requirePermission("movies:write", app.deleteMovieTitleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.listReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.createReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews/:review_id", app.requirePermission("movies:read", app.showReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id/reviews/:review_id", app.requirePermission("movies:read", app.updateReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/reviews/:review_id", app.requirePermission("movies:read", app.deleteReviewHandler))
 
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission("movies:read", app.listGenresHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres", app.requirePermission("genres:write", app.createGenreHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/genres/:genre", app.requirePermission("movies:read", app.showGenreHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/genres/:genre", app.requirePermission("genres:write", app.updateGenreHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/genres/:genre", app.requirePermission("genres:write", app.deleteGenreHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/genres/:genre/names", app.requirePermission("movies:read", app.listGenreNamesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/genres/:genre/names/:lang", app.requirePermission("movies:write", app.setGenreNameHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/genres/:genre/names/:lang", app.requirePermission("movies:write", app.deleteGenreNameHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.requirePermission("people:read", app.listPeopleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission("people:write", app.createPersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.requirePermission("people:read", app.showPersonHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission("people:write", app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission("people:write", app.deletePersonHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/users", app.idempotent(app.registerUserHandler))
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watchlist", app.requirePermission("movies:read", app.showWatchlistHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/watchlist", app.requirePermission("movies:read", app.addToWatchlistHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watchlist", app.requirePermission("movies:read", app.removeFromWatchlistHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks", app.requirePermission("webhooks:write", app.listWebhooksHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks", app.requirePermission("webhooks:write", app.createWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id", app.requirePermission("webhooks:write", app.showWebhookHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/webhooks/:id", app.requirePermission("webhooks:write", app.updateWebhookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/webhooks/:id", app.requirePermission("webhooks:write", app.deleteWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries", app.requirePermission("webhooks:write", app.listWebhookDeliveriesHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/jobs", app.requirePermission("jobs:write", app.listJobsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/jobs/:id/retry", app.requirePermission("jobs:write", app.retryJobHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/mail/suppressions", app.requirePermission("mail:write", app.listSuppressionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/mail/suppressions/:email", app.requirePermission("mail:write", app.deleteSuppressionHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
 
 	// Register a new Get /debug/vars endpoint pointing to the expvar handler.
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 
+	// The email previews are only for development, as they would let anyone see the templates.
+	if app.config.env == "development" {
+		router.HandlerFunc(http.MethodGet, "/debug/mail/:template", app.previewMailHandler)
+	}
+
 	// Wrap the router with the panic recovery middleware.
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	return app.metrics(app.compress(app.recoverPanic(app.negotiate(app.enableCORS(app.rateLimit(app.authenticate(router)))))))
 }
@@ -21,6 +21,14 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// The changes feeds stay open until the client goes away, so Shutdown() would wait for them until its deadline.
+	// Instead we end them all as soon as the shutdown starts, by closing the change broker.
+	srv.RegisterOnShutdown(app.changes.close)
+
+	// Create a done channel. This is closed when the server starts shutting down, to tell our long-running background
+	// workers that they should stop.
+	done := make(chan struct{})
+
 	// Create a shutdownError channel. We will use this to receive any errors returned by the graceful Shutdown()
 	// function.
 	shutdownError := make(chan error)
@@ -48,11 +56,13 @@ func (app *application) serve() error {
 		// Call Shutdown() on our server, passing in the context we just made. Shutdown() will return nil if
 		// the graceful shutdown was successful, or an error (which may happen because of a problem closing the
 		// listeners, or because the shutdown didn't complete before the 20-seconds context deadline is hit).
-		// We relay this return value to the shutdownError channel.
+		// We relay this return value to the shutdownError channel, once the background tasks have finished. They are
+		// waited for even if Shutdown() failed, so that running jobs and webhook deliveries aren't cut off.
 		err := srv.Shutdown(ctx)
-		if err != nil {
-			shutdownError <- err
-		}
+
+		// Tell the background workers to stop. The job workers stop claiming new jobs, but finish the ones that they
+		// are running first.
+		close(done)
 
 		// Log a message to say that we're waiting for any background goroutines to complete their tasks.
 		app.logger.PrintInfo("completing background tasks", map[string]string{
@@ -60,12 +70,25 @@ func (app *application) serve() error {
 		})
 
 		// Call Wait() to block until our WaitGroup counter is zero --- essentially blocking until the background
-		// goroutines have finished. Then we return nil on the shutdown completed without any issues.
+		// goroutines have finished. Then we send the result of Shutdown(), which is nil if the shutdown completed
+		// without any issues.
 		app.wg.Wait()
-		shutdownError <- nil
+		shutdownError <- err
 
 	}()
 
+	// Start the background workers which purge expired movies from the trash, expired idempotency keys, old movie
+	// changes, old webhook deliveries and old jobs, the worker which listens for movie changes to pass on to the
+	// changes feeds, the worker which sends webhook deliveries, and the pool of workers which run the background jobs.
+	app.purgeDeletedMovies(done)
+	app.deleteExpiredIdempotencyKeys(done)
+	app.deleteOldMovieChanges(done)
+	app.deleteOldWebhookDeliveries(done)
+	app.deleteOldJobs(done)
+	app.listenForMovieChanges(done)
+	app.deliverWebhooks(done)
+	app.runJobs(done)
+
 	// Likewise log a "starting server" message.
 	app.logger.PrintInfo("starting server", map[string]string{
 		"addr": srv.Addr,
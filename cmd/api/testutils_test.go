@@ -0,0 +1,50 @@
+package main
+
+import (
+	"database/sql"
+	"greenlight.luismatosgarcia.dev/internal/jsonlog"
+	"io"
+	"os"
+	"testing"
+)
+
+// The newTestApplication() helper returns an application for the tests, with the logs thrown away and the settings
+// which the code under test needs set to their defaults.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	app := &application{
+		logger:  jsonlog.New(io.Discard, jsonlog.LevelInfo),
+		changes: newChangeBroker(),
+	}
+
+	app.config.env = "development"
+	app.config.webhooks.maxAttempts = 10
+
+	return app
+}
+
+// The newTestDB() helper connects to the Postgres database named by the GREENLIGHT_TEST_DB_DSN environment variable,
+// which must have the migrations applied. Tests which need a real database are skipped when it isn't set.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("GREENLIGHT_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("GREENLIGHT_TEST_DB_DSN is not set")
+	}
+
+	var cfg config
+	cfg.db.dsn = dsn
+	cfg.db.maxOpenConns = 5
+	cfg.db.maxIdleConns = 5
+	cfg.db.maxIdleTime = "1m"
+
+	db, err := openDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"greenlight.luismatosgarcia.dev/internal/data"
+	"greenlight.luismatosgarcia.dev/internal/validator"
+	"net/http"
+	"strings"
+)
+
+// The listMovieTitlesHandler handles the "GET /v1/movies/:id/titles" endpoint, returning all the localized titles
+// for a movie.
+func (app *application) listMovieTitlesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id, "id")
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	titles, err := app.models.Translations.GetTitlesForMovie(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"titles": titles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The setMovieTitleHandler handles the "PUT /v1/movies/:id/titles/:lang" endpoint, which adds or replaces the movie's
+// title in one language.
+func (app *application) setMovieTitleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id, "id")
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Title string `json:"title"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	title := &data.MovieTitle{
+		Language: app.readLanguageParam(r),
+		Title:    input.Title,
+	}
+
+	v := validator.New()
+
+	if data.ValidateMovieTitle(v, title); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Translations.SetTitle(id, title)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"title": title}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteMovieTitleHandler handles the "DELETE /v1/movies/:id/titles/:lang" endpoint.
+func (app *application) deleteMovieTitleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Translations.DeleteTitle(id, app.readLanguageParam(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "title successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listGenreNamesHandler handles the "GET /v1/genres/:genre/names" endpoint, returning all the localized names for
+// a genre code.
+func (app *application) listGenreNamesHandler(w http.ResponseWriter, r *http.Request) {
+	names, err := app.models.Translations.GetNamesForGenre(app.readGenreParam(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"names": names}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The setGenreNameHandler handles the "PUT /v1/genres/:genre/names/:lang" endpoint, which adds or replaces the name
+// of a genre from the catalogue in one language.
+func (app *application) setGenreNameHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	genre, err := app.models.Genres.Get(app.readGenreParam(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	name := &data.GenreName{
+		Language: app.readLanguageParam(r),
+		Name:     input.Name,
+	}
+
+	v := validator.New()
+
+	if data.ValidateGenreName(v, name); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Translations.SetGenreName(genre.Code, name)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"name": name}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteGenreNameHandler handles the "DELETE /v1/genres/:genre/names/:lang" endpoint.
+func (app *application) deleteGenreNameHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.Translations.DeleteGenreName(app.readGenreParam(r), app.readLanguageParam(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "genre name successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readLanguageParam() helper returns the "lang" URL parameter, lowercased so that it matches the stored tags.
+func (app *application) readLanguageParam(r *http.Request) string {
+	return strings.ToLower(httprouter.ParamsFromContext(r.Context()).ByName("lang"))
+}
+
+// The readGenreParam() helper returns the "genre" URL parameter.
+func (app *application) readGenreParam(r *http.Request) string {
+	return httprouter.ParamsFromContext(r.Context()).ByName("genre")
+}
@@ -5,7 +5,6 @@ import (
 	"greenlight.luismatosgarcia.dev/internal/data"
 	"greenlight.luismatosgarcia.dev/internal/validator"
 	"net/http"
-	"time"
 )
 
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -40,14 +39,33 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	v := validator.New()
 
+	// Read the languages that the user would like their welcome email in.
+	languages := app.readLanguages(r, v)
+
 	// Validate the user struct and return the error messages to the client if any of the checks fail.
 	if data.ValidateUser(v, user); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	// Insert the user data into the database.
-	err = app.models.Users.Insert(user)
+	// Insert the user, give them their permissions and queue the job which sends their welcome email, all in one
+	// transaction. That way the email job can't be lost: if the user exists then so does the job, and the job queue
+	// retries it until the email is sent.
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Users.Insert(user)
+		if err != nil {
+			return err
+		}
+
+		// Add the "movies:read" and "people:read" permissions for the new user.
+		err = tx.Permissions.AddForUser(user.ID, "movies:read", "people:read")
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Jobs.Enqueue(jobWelcomeEmail, welcomeEmailPayload{UserID: user.ID, Languages: languages}, app.config.jobs.maxAttempts)
+		return err
+	})
 	if err != nil {
 		switch {
 		// If we get a ErrDuplicateEmail error, use the v.AddError() method manually add a message to the
@@ -63,41 +81,11 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Add the "movies:read" permission for the new user.
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	// After the user record has been created in the database, generate a new activation token for the user.
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	// Use the background helper to execute an anonymous function that sends the welcome email.
-	app.background(func() {
-		// As there are now multiple pieces of data that we want to pass to our email templates, we create a map to
-		// act as a 'holding structure' for the data. This contains the plaintext version of the activation token
-		// for the user, along with their ID.
-		data := map[string]any{
-			"activationToken": token.Plaintext,
-			"userId":          user.ID,
-		}
-		// Call the Send() method on our Mailer, passing in the user's email address, name of the template file, and
-		// the User struct containing the new user's data.
-		err = app.mailer.Send(user.Email, "user_welcome.gohtml", data)
-		if err != nil {
-			// Importantly, if there is an error sending the email then we use the app.logger.PrintError()
-			// helper to manage it, instead of the app.serverErrorResponse() helper like before.
-			app.logger.PrintError(err, nil)
-		}
-	})
+	// Let the job runner know that there's a job waiting, so that the email goes out straight away.
+	app.jobs.Wake()
 
 	// Write a JSON response containing the user data along with a 201 Created status code.
-	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
+	err = app.writeResponse(w, r, http.StatusAccepted, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -165,7 +153,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Send the updated user details to the client in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
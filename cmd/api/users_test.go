@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"greenlight.luismatosgarcia.dev/internal/data"
+	"greenlight.luismatosgarcia.dev/internal/jobs"
+	"greenlight.luismatosgarcia.dev/internal/mailer"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRegisterUser registers a user through the API and checks that the welcome email job sends them an activation
+// token (captured by the in-memory transport) which then activates their account.
+func TestRegisterUser(t *testing.T) {
+	db := newTestDB(t)
+
+	app := newTestApplication(t)
+	app.config.jobs.maxAttempts = 1
+	app.models = data.NewModels(db)
+
+	recorder := mailer.NewRecorder()
+	app.mailer = mailer.WithSuppressionList(mailer.New(recorder, "Greenlight <no-reply@greenlight.test>"), app.models.Suppressions)
+
+	app.jobs = jobs.NewRunner(app.models.Jobs, app.logger, 1, time.Minute)
+	app.registerJobHandlers()
+
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+
+	email := fmt.Sprintf("alice-%d@example.com", time.Now().UnixNano())
+
+	body := fmt.Sprintf(`{"name": "Alice Smith", "email": %q, "password": "pa55word1234"}`, email)
+
+	res, err := ts.Client().Post(ts.URL+"/v1/users", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("got status %d; want %d", res.StatusCode, http.StatusAccepted)
+	}
+
+	var registered struct {
+		User struct {
+			ID int64 `json:"id"`
+		} `json:"user"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&registered)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM jobs WHERE kind = $1 AND (payload->>'user_id')::bigint = $2`, jobWelcomeEmail, registered.User.ID)
+		db.Exec(`DELETE FROM users WHERE id = $1`, registered.User.ID)
+	})
+
+	// Run the job workers until the welcome email has been sent.
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		app.jobs.Run(done)
+		close(stopped)
+	}()
+
+	var messages []*mailer.Message
+
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if messages = recorder.Messages(); len(messages) > 0 {
+			break
+		}
+	}
+
+	close(done)
+	<-stopped
+
+	if len(messages) != 1 {
+		t.Fatalf("got %d emails; want 1", len(messages))
+	}
+
+	msg := messages[0]
+
+	if msg.To != email {
+		t.Errorf("got email to %q; want %q", msg.To, email)
+	}
+
+	if msg.Subject != "Welcome to Greenlight!" {
+		t.Errorf("got subject %q", msg.Subject)
+	}
+
+	match := regexp.MustCompile(`\{"token": "([A-Z0-9]{26})"\}`).FindStringSubmatch(msg.PlainBody)
+	if match == nil {
+		t.Fatalf("no activation token in the email:\n%s", msg.PlainBody)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/v1/users/activated", strings.NewReader(fmt.Sprintf(`{"token": %q}`, match[1])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err = ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("activating: got status %d; want %d", res.StatusCode, http.StatusOK)
+	}
+
+	user, err := app.models.Users.Get(registered.User.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !user.Activated {
+		t.Error("user was not activated")
+	}
+}
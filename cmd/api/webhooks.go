@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"greenlight.luismatosgarcia.dev/internal/data"
+	"greenlight.luismatosgarcia.dev/internal/jobs"
+	"greenlight.luismatosgarcia.dev/internal/validator"
+	"greenlight.luismatosgarcia.dev/internal/webhook"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The createWebhookHandler handles the "POST /v1/webhooks" endpoint. If the request doesn't include a secret then a
+// random one is generated. The secret is only ever returned in the response to this request, so the client should
+// keep it.
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Secret string   `json:"secret"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	hook := &data.Webhook{
+		URL:    input.URL,
+		Secret: input.Secret,
+		Events: input.Events,
+		Active: true,
+	}
+
+	if input.Active != nil {
+		hook.Active = *input.Active
+	}
+
+	if hook.Secret == "" {
+		hook.Secret, err = generateWebhookSecret()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	v := validator.New()
+
+	if data.ValidateWebhook(v, hook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Insert(hook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d", hook.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"webhook": hook, "secret": hook.Secret}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listWebhooksHandler handles the "GET /v1/webhooks" endpoint.
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	hooks, err := app.models.Webhooks.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"webhooks": hooks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showWebhookHandler handles the "GET /v1/webhooks/:id" endpoint.
+func (app *application) showWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	hook, ok := app.readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"webhook": hook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateWebhookHandler handles the "PATCH /v1/webhooks/:id" endpoint. Only the fields which are present in the
+// request body are changed, so a webhook can be paused by sending {"active": false}, or its secret rotated on its own.
+func (app *application) updateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	hook, ok := app.readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		URL    *string  `json:"url"`
+		Secret *string  `json:"secret"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.URL != nil {
+		hook.URL = *input.URL
+	}
+	if input.Secret != nil {
+		hook.Secret = *input.Secret
+	}
+	if input.Events != nil {
+		hook.Events = input.Events
+	}
+	if input.Active != nil {
+		hook.Active = *input.Active
+	}
+
+	v := validator.New()
+
+	if data.ValidateWebhook(v, hook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Update(hook)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"webhook": hook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteWebhookHandler handles the "DELETE /v1/webhooks/:id" endpoint. Any deliveries which haven't been sent yet
+// are dropped along with the webhook.
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Webhooks.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "webhook successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listWebhookDeliveriesHandler handles the "GET /v1/webhooks/:id/deliveries" endpoint, which is the delivery log
+// for a webhook: every event queued for it, with the number of attempts and the outcome of the latest one. By default
+// the newest deliveries are listed first, and they can be filtered by status.
+func (app *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	hook, ok := app.readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Status string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafeList = []string{"id", "next_attempt_at", "-id", "-next_attempt_at"}
+
+	if input.Status != "" {
+		v.Check(validator.PermittedValue(input.Status, data.DeliveryPending, data.DeliverySucceeded, data.DeliveryFailed), "status", "must be pending, succeeded or failed")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deliveries, metadata, err := app.models.Deliveries.GetAllForWebhook(hook.ID, input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"deliveries": deliveries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readWebhook() helper reads the webhook in the URL, sending a 404 Not Found response (and returning false) if it
+// doesn't exist.
+func (app *application) readWebhook(w http.ResponseWriter, r *http.Request) (*data.Webhook, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	hook, err := app.models.Webhooks.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return hook, true
+}
+
+// The generateWebhookSecret() function returns 32 random bytes, hex-encoded.
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// The webhookBatchSize constant is how many deliveries the webhook worker claims (and sends at the same time) at once.
+const webhookBatchSize = 10
+
+// The deliverWebhooks() method starts a background worker which sends the deliveries in the webhook outbox. It wakes
+// up whenever the movie changes listener hears about a change (as the trigger which records the change also queues the
+// deliveries), and otherwise every few seconds to pick up retries. Once the done channel is closed it stops claiming
+// deliveries, but lets any which are being sent finish, so that their outcome is recorded.
+func (app *application) deliverWebhooks(done <-chan struct{}) {
+	notifications, unsubscribe := app.changes.subscribe()
+
+	// Receivers get a fixed time to respond, and we don't follow redirects, as a delivery could otherwise be sent
+	// somewhere other than the URL that was registered.
+	client := &http.Client{
+		Timeout: app.config.webhooks.timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	app.background(func() {
+		defer unsubscribe()
+
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			app.sendDueWebhookDeliveries(done, client)
+
+			select {
+			case <-done:
+				return
+
+			// The broker closes the channel when the server is shutting down. After that we just use the ticker, as
+			// receiving from a nil channel blocks forever.
+			case _, ok := <-notifications:
+				if !ok {
+					notifications = nil
+				}
+
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// The sendDueWebhookDeliveries() method claims and sends batches of deliveries until there aren't any more due, or the
+// done channel is closed.
+func (app *application) sendDueWebhookDeliveries(done <-chan struct{}, client *http.Client) {
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		// Claim the deliveries for longer than it could take to send them, so that no other worker picks them up in
+		// the meantime.
+		deliveries, err := app.models.Deliveries.Claim(webhookBatchSize, app.config.webhooks.timeout+time.Minute)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+
+		var wg sync.WaitGroup
+
+		for _, delivery := range deliveries {
+			wg.Add(1)
+
+			go func(delivery *data.WebhookDelivery) {
+				defer wg.Done()
+				app.attemptWebhookDelivery(client, delivery)
+			}(delivery)
+		}
+
+		wg.Wait()
+
+		if len(deliveries) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+// The attemptWebhookDelivery() method sends a delivery and records the outcome. Any 2xx response counts as success.
+// Anything else is retried with exponential backoff, until the delivery has been attempted the configured maximum
+// number of times and is marked as failed.
+func (app *application) attemptWebhookDelivery(client *http.Client, delivery *data.WebhookDelivery) {
+	status, err := sendWebhook(client, delivery)
+
+	delivery.ResponseStatus = status
+	delivery.LastError = ""
+	delivery.NextAttemptAt = nil
+
+	switch {
+	case err == nil && status >= 200 && status < 300:
+		delivery.Status = data.DeliverySucceeded
+
+	default:
+		if err != nil {
+			delivery.LastError = err.Error()
+		} else {
+			delivery.LastError = fmt.Sprintf("receiver responded with %d %s", status, http.StatusText(status))
+		}
+
+		// The attempts count doesn't include this attempt until it has been recorded.
+		attempts := delivery.Attempts + 1
+
+		if attempts >= app.config.webhooks.maxAttempts {
+			delivery.Status = data.DeliveryFailed
+		} else {
+			next := time.Now().Add(webhookBackoff(attempts))
+			delivery.Status = data.DeliveryPending
+			delivery.NextAttemptAt = &next
+		}
+	}
+
+	err = app.models.Deliveries.RecordAttempt(delivery)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.logger.PrintError(err, map[string]string{"delivery_id": strconv.FormatInt(delivery.ID, 10)})
+		return
+	}
+
+	if delivery.Status == data.DeliveryFailed {
+		app.logger.PrintError(errors.New("webhook delivery failed"), map[string]string{
+			"delivery_id": strconv.FormatInt(delivery.ID, 10),
+			"webhook_id":  strconv.FormatInt(delivery.WebhookID, 10),
+			"error":       delivery.LastError,
+		})
+	}
+}
+
+// The sendWebhook() function POSTs a delivery's payload to its webhook's URL, signed with the webhook's secret, and
+// returns the status code of the response. The response body is read (up to a limit) and discarded, so that the
+// connection can be reused.
+func sendWebhook(client *http.Client, delivery *data.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Greenlight-Webhooks/"+version)
+	req.Header.Set(webhook.EventHeader, delivery.Event)
+	req.Header.Set(webhook.DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+
+	now := time.Now()
+	req.Header.Set(webhook.TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(delivery.Secret, now, delivery.Payload))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+
+	return res.StatusCode, nil
+}
+
+// The webhookBackoff() function returns how long to wait before the next attempt at a delivery which has failed the
+// given number of times. The wait starts at 30 seconds and doubles with every failure, up to 6 hours, with some
+// jitter added by jobs.Backoff().
+func webhookBackoff(attempts int) time.Duration {
+	return jobs.Backoff(attempts, 30*time.Second, 6*time.Hour)
+}
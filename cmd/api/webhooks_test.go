@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"greenlight.luismatosgarcia.dev/internal/data"
+	"greenlight.luismatosgarcia.dev/internal/webhook"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// deliveryStore is a database/sql connector standing in for Postgres in the webhook tests. It only answers the query
+// made by WebhookDeliveryModel.RecordAttempt(), and keeps the attempt counts and the statuses recorded for each
+// delivery.
+type deliveryStore struct {
+	mu       sync.Mutex
+	attempts map[int64]int64
+	statuses map[int64][]string
+}
+
+func newDeliveryStore() *deliveryStore {
+	return &deliveryStore{attempts: make(map[int64]int64), statuses: make(map[int64][]string)}
+}
+
+func (s *deliveryStore) Connect(context.Context) (driver.Conn, error) { return deliveryConn{s}, nil }
+func (s *deliveryStore) Driver() driver.Driver                        { return nil }
+
+type deliveryConn struct{ store *deliveryStore }
+
+func (c deliveryConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c deliveryConn) Close() error                        { return nil }
+func (c deliveryConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c deliveryConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if !strings.Contains(query, "UPDATE webhook_deliveries") {
+		return nil, fmt.Errorf("unexpected query: %s", query)
+	}
+
+	status := args[0].Value.(string)
+	id := args[4].Value.(int64)
+
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	c.store.attempts[id]++
+	c.store.statuses[id] = append(c.store.statuses[id], status)
+
+	return &singleRow{
+		columns: []string{"attempts", "last_attempt_at"},
+		values:  []driver.Value{c.store.attempts[id], time.Now()},
+	}, nil
+}
+
+type singleRow struct {
+	columns []string
+	values  []driver.Value
+	done    bool
+}
+
+func (r *singleRow) Columns() []string { return r.columns }
+func (r *singleRow) Close() error      { return nil }
+
+func (r *singleRow) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+
+	r.done = true
+	copy(dest, r.values)
+
+	return nil
+}
+
+// The newWebhookTestApplication() helper returns a test application whose deliveries are recorded in the store.
+func newWebhookTestApplication(t *testing.T, store *deliveryStore) *application {
+	app := newTestApplication(t)
+
+	db := sql.OpenDB(store)
+	t.Cleanup(func() { db.Close() })
+
+	app.models.Deliveries = data.WebhookDeliveryModel{DB: db}
+
+	return app
+}
+
+// The newTestDelivery() helper returns a delivery to the receiver, as it would be claimed for sending.
+func newTestDelivery(url string) *data.WebhookDelivery {
+	return &data.WebhookDelivery{
+		ID:        42,
+		WebhookID: 7,
+		Event:     "movie.updated",
+		Payload:   []byte(`{"event":"movie.updated","movie":{"id":1,"version":2}}`),
+		Status:    data.DeliveryPending,
+		URL:       url,
+		Secret:    "0123456789abcdef0123456789abcdef",
+	}
+}
+
+func TestSendWebhook(t *testing.T) {
+	delivery := newTestDelivery("")
+
+	var verifyErr error
+	var header http.Header
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		header = r.Header.Clone()
+		verifyErr = webhook.Verify(delivery.Secret, r.Header.Get(webhook.TimestampHeader), r.Header.Get(webhook.SignatureHeader), body, 5*time.Minute)
+
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer receiver.Close()
+
+	delivery.URL = receiver.URL
+
+	status, err := sendWebhook(receiver.Client(), delivery)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status != http.StatusAccepted {
+		t.Errorf("got status %d; want %d", status, http.StatusAccepted)
+	}
+
+	if verifyErr != nil {
+		t.Errorf("receiver couldn't verify the signature: %v", verifyErr)
+	}
+
+	for name, want := range map[string]string{
+		"Content-Type":         "application/json",
+		webhook.EventHeader:    "movie.updated",
+		webhook.DeliveryHeader: "42",
+	} {
+		if got := header.Get(name); got != want {
+			t.Errorf("got %s header %q; want %q", name, got, want)
+		}
+	}
+}
+
+func TestAttemptWebhookDelivery(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		attempts    int
+		wantStatus  string
+		wantRetry   bool
+		wantLastErr string
+	}{
+		{name: "OK", status: http.StatusOK, wantStatus: data.DeliverySucceeded},
+		{name: "No content", status: http.StatusNoContent, wantStatus: data.DeliverySucceeded},
+		{name: "Redirect", status: http.StatusFound, wantStatus: data.DeliveryPending, wantRetry: true, wantLastErr: "receiver responded with 302 Found"},
+		{name: "Client error", status: http.StatusGone, wantStatus: data.DeliveryPending, wantRetry: true, wantLastErr: "receiver responded with 410 Gone"},
+		{name: "Server error", status: http.StatusInternalServerError, wantStatus: data.DeliveryPending, wantRetry: true, wantLastErr: "receiver responded with 500 Internal Server Error"},
+		{name: "Last attempt", status: http.StatusInternalServerError, attempts: 9, wantStatus: data.DeliveryFailed, wantLastErr: "receiver responded with 500 Internal Server Error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.status == http.StatusFound {
+					w.Header().Set("Location", "/elsewhere")
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer receiver.Close()
+
+			app := newWebhookTestApplication(t, newDeliveryStore())
+
+			client := receiver.Client()
+			client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+			delivery := newTestDelivery(receiver.URL)
+			delivery.Attempts = tt.attempts
+
+			app.attemptWebhookDelivery(client, delivery)
+
+			if delivery.Status != tt.wantStatus {
+				t.Errorf("got status %q; want %q", delivery.Status, tt.wantStatus)
+			}
+
+			if delivery.ResponseStatus != tt.status {
+				t.Errorf("got response status %d; want %d", delivery.ResponseStatus, tt.status)
+			}
+
+			if delivery.LastError != tt.wantLastErr {
+				t.Errorf("got last error %q; want %q", delivery.LastError, tt.wantLastErr)
+			}
+
+			if tt.wantRetry && (delivery.NextAttemptAt == nil || !delivery.NextAttemptAt.After(time.Now())) {
+				t.Errorf("got next attempt at %v; want a time in the future", delivery.NextAttemptAt)
+			}
+
+			if !tt.wantRetry && delivery.NextAttemptAt != nil {
+				t.Errorf("got next attempt at %v; want none", delivery.NextAttemptAt)
+			}
+		})
+	}
+}
+
+func TestAttemptWebhookDeliveryUnreachable(t *testing.T) {
+	receiver := httptest.NewServer(http.NotFoundHandler())
+	receiver.Close()
+
+	app := newWebhookTestApplication(t, newDeliveryStore())
+
+	delivery := newTestDelivery(receiver.URL)
+
+	app.attemptWebhookDelivery(receiver.Client(), delivery)
+
+	if delivery.Status != data.DeliveryPending || delivery.NextAttemptAt == nil {
+		t.Errorf("got status %q with next attempt at %v; want a pending retry", delivery.Status, delivery.NextAttemptAt)
+	}
+
+	if delivery.ResponseStatus != 0 || delivery.LastError == "" {
+		t.Errorf("got response status %d and last error %q; want no status and an error", delivery.ResponseStatus, delivery.LastError)
+	}
+}
+
+func TestAttemptWebhookDeliveryMaxAttempts(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	store := newDeliveryStore()
+
+	app := newWebhookTestApplication(t, store)
+	app.config.webhooks.maxAttempts = 3
+
+	delivery := newTestDelivery(receiver.URL)
+
+	// Each attempt records the delivery, which updates its attempts count from the store, just as the next claim would.
+	for i := 0; i < app.config.webhooks.maxAttempts; i++ {
+		app.attemptWebhookDelivery(receiver.Client(), delivery)
+	}
+
+	want := []string{data.DeliveryPending, data.DeliveryPending, data.DeliveryFailed}
+
+	if got := store.statuses[delivery.ID]; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got statuses %v; want %v", got, want)
+	}
+
+	if delivery.Attempts != app.config.webhooks.maxAttempts {
+		t.Errorf("got %d attempts; want %d", delivery.Attempts, app.config.webhooks.maxAttempts)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		min      time.Duration
+		max      time.Duration
+	}{
+		{1, 30 * time.Second, 36 * time.Second},
+		{2, time.Minute, 72 * time.Second},
+		{3, 2 * time.Minute, 144 * time.Second},
+		{5, 8 * time.Minute, 576 * time.Second},
+		{10, 256 * time.Minute, 307*time.Minute + 12*time.Second},
+		{11, 6 * time.Hour, 432 * time.Minute},
+		{20, 6 * time.Hour, 432 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		// The jitter is random, so try each one a few times.
+		for i := 0; i < 100; i++ {
+			got := webhookBackoff(tt.attempts)
+			if got < tt.min || got > tt.max {
+				t.Fatalf("attempt %d: got backoff %v; want between %v and %v", tt.attempts, got, tt.min, tt.max)
+			}
+		}
+	}
+}
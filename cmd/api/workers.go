@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// The runPeriodically() helper starts a background worker which calls fn once straight away and then on every tick of
+// the interval, until the done channel is closed.
+func (app *application) runPeriodically(done <-chan struct{}, interval time.Duration, fn func()) {
+	app.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			fn()
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// The purgeDeletedMovies() method starts a background worker which permanently deletes movies that have been in the
+// trash for longer than the configured retention period.
+func (app *application) purgeDeletedMovies(done <-chan struct{}) {
+	app.runPeriodically(done, app.config.trash.purgeInterval, func() {
+		purged, err := app.models.Movies.Purge(app.config.trash.retention)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+
+		if purged > 0 {
+			app.logger.PrintInfo("purged deleted movies", map[string]string{
+				"count": strconv.FormatInt(purged, 10),
+			})
+		}
+	})
+}
+
+// The deleteExpiredIdempotencyKeys() method starts a background worker which removes the stored responses for
+// idempotency keys once their replay window has passed.
+func (app *application) deleteExpiredIdempotencyKeys(done <-chan struct{}) {
+	app.runPeriodically(done, time.Hour, func() {
+		_, err := app.models.Idempotency.DeleteExpired()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+}
+
+// The deleteOldMovieChanges() method starts a background worker which removes the entries in the movie changes feed
+// once they are older than the configured retention period.
+func (app *application) deleteOldMovieChanges(done <-chan struct{}) {
+	app.runPeriodically(done, time.Hour, func() {
+		_, err := app.models.MovieChanges.DeleteOlderThan(app.config.changes.retention)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+}
+
+// The deleteOldWebhookDeliveries() method starts a background worker which removes the webhook deliveries that
+// succeeded or failed longer ago than the configured retention period from the delivery log.
+func (app *application) deleteOldWebhookDeliveries(done <-chan struct{}) {
+	app.runPeriodically(done, time.Hour, func() {
+		_, err := app.models.Deliveries.DeleteOlderThan(app.config.webhooks.retention)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+}
+
+// The deleteOldJobs() method starts a background worker which removes the background jobs that succeeded longer ago
+// than the configured retention period.
+func (app *application) deleteOldJobs(done <-chan struct{}) {
+	app.runPeriodically(done, time.Hour, func() {
+		_, err := app.models.Jobs.DeleteSucceededOlderThan(app.config.jobs.retention)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+// The normalize-genres command is a one-time migration which converts the genres of every existing movie to the
+// canonical codes in the genre catalogue, so that spellings like "Sci-Fi" and "science fiction" are all stored as
+// "science-fiction". Genres which aren't in the catalogue are left alone and logged, so that they can be added to the
+// catalogue (as a new genre or an alias) before running the command again. Use -dry-run to see what would change.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"greenlight.luismatosgarcia.dev/internal/data"
+	"greenlight.luismatosgarcia.dev/internal/jsonlog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+func main() {
+	var (
+		dsn    string
+		dryRun bool
+	)
+
+	flag.StringVar(&dsn, "db-dsn", "", "PostgreSQL DSN")
+	flag.BoolVar(&dryRun, "dry-run", false, "Report the changes without saving them")
+	flag.Parse()
+
+	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	models := data.NewModels(db)
+
+	catalogue, err := models.Genres.Catalogue()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	changes, err := models.Genres.NormalizeMovieGenres(catalogue, dryRun)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	changed := 0
+
+	for _, change := range changes {
+		properties := map[string]string{
+			"movie_id": strconv.FormatInt(change.MovieID, 10),
+			"from":     strings.Join(change.From, ","),
+			"to":       strings.Join(change.To, ","),
+		}
+
+		if change.Changed {
+			changed++
+			logger.PrintInfo("normalized movie genres", properties)
+		}
+
+		if len(change.Unknown) > 0 {
+			properties["unknown"] = strings.Join(change.Unknown, ",")
+			logger.PrintInfo("movie has genres which aren't in the catalogue", properties)
+		}
+	}
+
+	message := "genre normalization complete"
+	if dryRun {
+		message = "genre normalization dry run complete, nothing was saved"
+	}
+
+	logger.PrintInfo(message, map[string]string{
+		"movies_changed": strconv.Itoa(changed),
+	})
+}
@@ -1,18 +1,23 @@
 module greenlight.luismatosgarcia.dev
 
-go 1.19
+go 1.20
 
 require (
-	github.com/felixge/httpsnoop v1.0.2
+	github.com/BurntSushi/toml v1.4.0
+	github.com/andybalholm/brotli v1.1.1
+	github.com/felixge/httpsnoop v1.0.4
 	github.com/go-mail/mail/v2 v2.3.0
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/lib/pq v1.10.2
 	github.com/tomasen/realip v0.0.0-20180522021738-f0c99a92ddce
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 	golang.org/x/crypto v0.0.0-20220829220503-c86fa9a7ed90
 	golang.org/x/time v0.0.0-20220722155302-e5dcc9cfc0b9
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
 	gopkg.in/mail.v2 v2.3.1 // indirect
 )
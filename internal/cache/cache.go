@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Response is a cached HTTP response. StoredAt is when it was added to the cache, which is used to work out its age.
+type Response struct {
+	Status   int
+	Header   http.Header
+	Body     []byte
+	StoredAt time.Time
+}
+
+// entry is the value held in each element of the LRU list.
+type entry struct {
+	key      string
+	response *Response
+}
+
+// Cache is a least-recently-used cache of responses, which is safe for concurrent use. It holds at most capacity
+// responses, and each response expires once it is older than the ttl.
+//
+// Every time the cache is invalidated its generation number goes up. A response can only be stored with the
+// generation that was current when the request started, so that a request which read the old data while the cache was
+// being invalidated can't put it back in afterwards.
+type Cache struct {
+	mu         sync.Mutex
+	capacity   int
+	ttl        time.Duration
+	generation uint64
+	items      map[string]*list.Element
+	order      *list.List
+}
+
+// New returns an empty Cache which holds up to capacity responses for at most ttl each.
+func New(capacity int, ttl time.Duration) *Cache {
+	return &Cache{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// TTL returns how long responses are kept for.
+func (c *Cache) TTL() time.Duration {
+	return c.ttl
+}
+
+// Generation returns the current generation number, which should be read before the response is generated and then
+// passed to Set().
+func (c *Cache) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// Get returns the response stored for the key, marking it as the most recently used. Expired responses are removed
+// and reported as missing.
+func (c *Cache) Get(key string) (*Response, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	response := element.Value.(*entry).response
+
+	if time.Since(response.StoredAt) > c.ttl {
+		c.remove(element)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+
+	return response, true
+}
+
+// Set stores the response for the key, removing the least recently used response if the cache is full. It does
+// nothing if the cache has been invalidated since the given generation.
+func (c *Cache) Set(key string, response *Response, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation || c.capacity <= 0 {
+		return
+	}
+
+	if element, ok := c.items[key]; ok {
+		element.Value.(*entry).response = response
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&entry{key: key, response: response})
+
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+// Invalidate removes every response from the cache and starts a new generation.
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// Len returns the number of responses in the cache.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// The remove() method takes an element out of both the list and the map. The mutex must be held.
+func (c *Cache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.items, element.Value.(*entry).key)
+}
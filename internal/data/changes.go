@@ -0,0 +1,114 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// MovieChangesChannel is the Postgres notification channel which the movies trigger notifies after every change.
+const MovieChangesChannel = "movie_changes"
+
+// MovieChange is an entry in the feed of changes to the movies, which is written by a trigger on the movies table.
+// The feed is ordered by the transaction which made each change, and then by its ID, so the IDs don't always increase
+// along the feed. A client which has seen a change can ask for the ones after it by its ID.
+type MovieChange struct {
+	ID        int64     `json:"id"`
+	ChangedAt time.Time `json:"changed_at"`
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+	Operation string    `json:"operation"`
+}
+
+// MovieChangeModel wraps the connection pool for the movie_changes table.
+type MovieChangeModel struct {
+	DB DBTX
+}
+
+// GetAllAfter returns up to limit changes which come after the change with the given ID in the feed, oldest first.
+// An afterID of zero starts from the beginning. If the change has already been removed, the feed carries on from the
+// changes with a greater ID.
+//
+// Concurrent transactions don't commit in the order that they took their change IDs in, so a change with a lower ID
+// can become visible after one with a higher ID. To make sure that a client never skips one, the feed is ordered by
+// transaction ID and then by change ID, and it only includes the changes made by transactions older than the oldest
+// one still running (the xmin of the current snapshot). Those transactions have all finished, so no change can turn
+// up before the end of the feed later on. The catch is that a long-running transaction holds the feed up until it
+// finishes.
+func (m MovieChangeModel) GetAllAfter(afterID int64, limit int) ([]*MovieChange, error) {
+	query := `
+		WITH after AS (
+			SELECT txid, id FROM movie_changes WHERE id = $1
+		)
+		SELECT id, changed_at, movie_id, version, operation
+		FROM movie_changes
+		WHERE txid < pg_snapshot_xmin(pg_current_snapshot())
+		AND CASE
+			WHEN EXISTS (SELECT 1 FROM after) THEN (txid, id) > (SELECT txid, id FROM after)
+			ELSE id > $1
+		END
+		ORDER BY txid, id
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []*MovieChange{}
+
+	for rows.Next() {
+		var change MovieChange
+
+		err := rows.Scan(&change.ID, &change.ChangedAt, &change.MovieID, &change.Version, &change.Operation)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, &change)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// LastID returns the ID of the last change in the feed which is visible to GetAllAfter(), or zero if there isn't one.
+func (m MovieChangeModel) LastID() (int64, error) {
+	query := `
+		SELECT COALESCE(
+			(SELECT id FROM movie_changes
+			WHERE txid < pg_snapshot_xmin(pg_current_snapshot())
+			ORDER BY txid DESC, id DESC
+			LIMIT 1),
+		0)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int64
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(&id)
+	return id, err
+}
+
+// DeleteOlderThan removes the changes which were made more than the retention period ago, and returns the number of
+// changes that were removed. Clients can't resume the feed from a change which has been removed.
+func (m MovieChangeModel) DeleteOlderThan(retention time.Duration) (int64, error) {
+	query := `DELETE FROM movie_changes WHERE changed_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
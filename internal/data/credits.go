@@ -0,0 +1,133 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"greenlight.luismatosgarcia.dev/internal/validator"
+	"time"
+)
+
+// ErrUnknownPerson is returned when a credit refers to a person who doesn't exist.
+var ErrUnknownPerson = errors.New("unknown person")
+
+// CreditRoles holds the roles that a person can be credited with on a movie.
+var CreditRoles = []string{"director", "writer", "producer", "actor", "composer", "cinematographer", "editor"}
+
+// Credit links a person to a movie in a specific role. The Character is the name of the character played, and only
+// applies to actors. The Name is the person's name, which is read from the people table for convenience and is
+// ignored when saving credits.
+type Credit struct {
+	PersonID  int64  `json:"person_id"`
+	Name      string `json:"name,omitempty"`
+	Role      string `json:"role"`
+	Character string `json:"character,omitempty"`
+}
+
+// CreditModel wraps the connection pool for the movie_credits table.
+type CreditModel struct {
+	DB      DBTX
+	changed movieChangeHook
+}
+
+// ValidateCredits checks each credit in a movie's list of credits. The errors are keyed by the position of the
+// credit in the list, for example "credits[2]".
+func ValidateCredits(v *validator.Validator, credits []*Credit) {
+	v.Check(len(credits) <= 500, "credits", "must not contain more than 500 credits")
+
+	seen := make(map[Credit]bool, len(credits))
+
+	for i, credit := range credits {
+		key := fmt.Sprintf("credits[%d]", i)
+
+		// A null in the list decodes to a nil credit, which there is nothing more to check.
+		if credit == nil {
+			v.AddError(key, "must be an object")
+			continue
+		}
+
+		v.Check(credit.PersonID > 0, key, "person_id must be provided")
+		v.Check(validator.PermittedValue(credit.Role, CreditRoles...), key, "role must be one of the permitted roles")
+		v.Check(credit.Character == "" || credit.Role == "actor", key, "character can only be given for actors")
+		v.Check(len(credit.Character) <= 500, key, "character must not be more than 500 bytes long")
+
+		// A person can have several roles on the same movie, but each role only once.
+		id := Credit{PersonID: credit.PersonID, Role: credit.Role}
+		v.Check(!seen[id], key, "must not duplicate another credit")
+		seen[id] = true
+	}
+}
+
+// GetAllForMovie returns the credits for a movie, in the order that they were saved.
+func (m CreditModel) GetAllForMovie(movieID int64) ([]*Credit, error) {
+	query := `
+		SELECT movie_credits.person_id, people.name, movie_credits.role, movie_credits.character
+		FROM movie_credits
+		INNER JOIN people ON people.id = movie_credits.person_id
+		WHERE movie_credits.movie_id = $1
+		ORDER BY movie_credits.position, people.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(&credit.PersonID, &credit.Name, &credit.Role, &credit.Character)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// ReplaceForMovie replaces all the credits for a movie with the provided list, in a single transaction. If any of
+// the credits refers to a person who doesn't exist, nothing is changed and an ErrUnknownPerson error is returned.
+func (m CreditModel) ReplaceForMovie(movieID int64, credits []*Credit) error {
+	// The movies can be filtered by the people credited on them, so changing the credits changes the movie lists.
+	defer m.changed.notify()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx DBTX) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM movie_credits WHERE movie_id = $1`, movieID)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			INSERT INTO movie_credits (movie_id, person_id, role, character, position)
+			VALUES ($1, $2, $3, $4, $5)`
+
+		for i, credit := range credits {
+			_, err = tx.ExecContext(ctx, query, movieID, credit.PersonID, credit.Role, credit.Character, i)
+			if err != nil {
+				// A foreign key violation means that there is no person with the given ID.
+				var pqErr *pq.Error
+				if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+					return ErrUnknownPerson
+				}
+				return err
+			}
+		}
+
+		return nil
+	})
+}
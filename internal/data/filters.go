@@ -1,6 +1,7 @@
 package data
 
 import (
+	"fmt"
 	"greenlight.luismatosgarcia.dev/internal/validator"
 	"math"
 	"strings"
@@ -11,6 +12,10 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+	// Fields holds the fields that the client wants in the response, and FieldSafeList the fields it may ask for.
+	// An empty Fields slice means that all fields should be returned.
+	Fields        []string
+	FieldSafeList []string
 }
 
 // Define a new Metadata struct for holding the pagination metadata.
@@ -31,6 +36,21 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 
 	// Check that the sort parameter matches a value in the safelist.
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+
+	// Check that the fields parameter only contains values in the safelist.
+	ValidateFields(v, f.Fields, f.FieldSafeList)
+}
+
+// ValidateFields checks that every requested field is in the safelist and that no field is requested twice.
+func ValidateFields(v *validator.Validator, fields []string, safeList []string) {
+	for _, field := range fields {
+		if !validator.PermittedValue(field, safeList...) {
+			v.AddError("fields", fmt.Sprintf("unknown field %q, must be one of: %s", field, strings.Join(safeList, ", ")))
+			return
+		}
+	}
+
+	v.Check(validator.Unique(fields), "fields", "must not contain duplicate values")
 }
 
 // Check that the client-provided Sort field matches one of the entries in our safelist and if it does, extract
@@ -0,0 +1,365 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"greenlight.luismatosgarcia.dev/internal/validator"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDuplicateGenre = errors.New("duplicate genre")
+	ErrGenreInUse     = errors.New("genre in use")
+)
+
+// GenreCodeRX is the format of a canonical genre code: lowercase words separated by single hyphens, like
+// "science-fiction".
+var GenreCodeRX = regexp.MustCompile("^[a-z0-9]+(?:-[a-z0-9]+)*$")
+
+// Genre is an entry in the genre catalogue. The Code is what's stored in a movie's genres array, and the Aliases are
+// other spellings which are accepted as input and converted to the code.
+type Genre struct {
+	Code      string    `json:"code"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	Aliases   []string  `json:"aliases"`
+	Version   int32     `json:"version"`
+}
+
+// GenreCatalogue maps every accepted spelling of a genre (its code, name and aliases, normalized by genreKey()) to
+// the genre's canonical code.
+type GenreCatalogue map[string]string
+
+// Lookup returns the canonical code for a genre, ignoring differences in case and whitespace.
+func (c GenreCatalogue) Lookup(genre string) (string, bool) {
+	code, ok := c[genreKey(genre)]
+	return code, ok
+}
+
+// The genreKey() function normalizes a genre spelling for lookups, by lowercasing it and collapsing any runs of
+// whitespace into a single space.
+func genreKey(genre string) string {
+	return strings.ToLower(strings.Join(strings.Fields(genre), " "))
+}
+
+// GenreModel wraps the connection pool for the genres table.
+type GenreModel struct {
+	DB      DBTX
+	changed movieChangeHook
+}
+
+// ValidateGenre checks a genre before it is saved. The catalogue is used to make sure that none of the genre's
+// spellings already belong to a different genre.
+func ValidateGenre(v *validator.Validator, genre *Genre, catalogue GenreCatalogue) {
+	v.Check(genre.Code != "", "code", "must be provided")
+	v.Check(len(genre.Code) <= 100, "code", "must not be more than 100 bytes long")
+	v.Check(validator.Matches(genre.Code, GenreCodeRX), "code", "must only contain lowercase letters, digits and hyphens")
+
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(genre.Aliases != nil, "aliases", "must be provided")
+	v.Check(len(genre.Aliases) <= 20, "aliases", "must not contain more than 20 aliases")
+
+	for _, alias := range genre.Aliases {
+		v.Check(genreKey(alias) != "", "aliases", "must not contain empty values")
+		v.Check(len(alias) <= 100, "aliases", "must not contain values more than 100 bytes long")
+	}
+
+	for _, spelling := range append([]string{genre.Code, genre.Name}, genre.Aliases...) {
+		if code, ok := catalogue.Lookup(spelling); ok && code != genre.Code {
+			v.AddError("aliases", "must not contain a name or alias of the "+code+" genre")
+		}
+	}
+}
+
+// Catalogue returns the lookup table for every genre in the catalogue.
+func (m GenreModel) Catalogue() (GenreCatalogue, error) {
+	genres, err := m.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	catalogue := make(GenreCatalogue)
+
+	for _, genre := range genres {
+		for _, spelling := range append([]string{genre.Code, genre.Name}, genre.Aliases...) {
+			catalogue[genreKey(spelling)] = genre.Code
+		}
+	}
+
+	return catalogue, nil
+}
+
+// GetAll returns every genre in the catalogue, ordered by code. The catalogue is small, so it isn't paginated.
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `
+		SELECT code, created_at, name, aliases, version
+		FROM genres
+		ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(&genre.Code, &genre.CreatedAt, &genre.Name, pq.Array(&genre.Aliases), &genre.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// Get returns the genre with the given code, or an ErrRecordNotFound error if there isn't one.
+func (m GenreModel) Get(code string) (*Genre, error) {
+	query := `
+		SELECT code, created_at, name, aliases, version
+		FROM genres
+		WHERE code = $1`
+
+	var genre Genre
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, code).Scan(
+		&genre.Code,
+		&genre.CreatedAt,
+		&genre.Name,
+		pq.Array(&genre.Aliases),
+		&genre.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &genre, nil
+}
+
+// Insert adds a new genre to the catalogue, returning an ErrDuplicateGenre error if the code is already taken.
+func (m GenreModel) Insert(genre *Genre) error {
+	query := `
+		INSERT INTO genres (code, name, aliases)
+		VALUES ($1, $2, $3)
+		RETURNING created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, genre.Code, genre.Name, pq.Array(genre.Aliases)).Scan(&genre.CreatedAt, &genre.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "genres_pkey"`:
+			return ErrDuplicateGenre
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Update saves the name and aliases of a genre, using the version number to check for edit conflicts. The code
+// can't be changed, as it is stored in the movies which have the genre.
+func (m GenreModel) Update(genre *Genre) error {
+	query := `
+		UPDATE genres SET name = $1, aliases = $2, version = version + 1
+		WHERE code = $3 AND version = $4
+		RETURNING version`
+
+	args := []any{genre.Name, pq.Array(genre.Aliases), genre.Code, genre.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&genre.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a genre from the catalogue, along with its translated names. It returns an ErrGenreInUse error if
+// any movie (including those in the trash) still has the genre.
+func (m GenreModel) Delete(code string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx DBTX) error {
+		// Lock the genre first, so that it can't be edited while we check whether it is in use.
+		var locked string
+
+		err := tx.QueryRowContext(ctx, `SELECT code FROM genres WHERE code = $1 FOR UPDATE`, code).Scan(&locked)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		var inUse bool
+
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movies WHERE $1 = ANY(genres))`, code).Scan(&inUse)
+		if err != nil {
+			return err
+		}
+
+		if inUse {
+			return ErrGenreInUse
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM genre_translations WHERE genre = $1`, code)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM genres WHERE code = $1`, code)
+		return err
+	})
+}
+
+// GenreNormalization describes the change made to one movie's genres by NormalizeMovieGenres(). Unknown lists any
+// genres which aren't in the catalogue, and so were left as they were.
+type GenreNormalization struct {
+	MovieID int64    `json:"movie_id"`
+	From    []string `json:"from"`
+	To      []string `json:"to"`
+	Changed bool     `json:"changed"`
+	Unknown []string `json:"unknown,omitempty"`
+}
+
+// NormalizeMovieGenres converts the genres of every movie (including those in the trash) to their canonical codes,
+// removing any duplicates that this creates. Genres which aren't in the catalogue are kept as they are and reported,
+// so that they can be added to the catalogue and the normalization run again. Each changed movie gets a new version
+// and a revision with no user. If dryRun is true then nothing is saved, but the changes that would be made are still
+// returned.
+func (m GenreModel) NormalizeMovieGenres(catalogue GenreCatalogue, dryRun bool) ([]*GenreNormalization, error) {
+	if !dryRun {
+		defer m.changed.notify()
+	}
+
+	// This runs once over the whole table, so allow much longer than the usual timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	changes := []*GenreNormalization{}
+
+	err := withTx(ctx, m.DB, func(tx DBTX) error {
+		// Read all the movies up front, as we can't run the updates on the transaction while the rows are still open.
+		query := `SELECT id, genres FROM movies ORDER BY id FOR UPDATE`
+
+		rows, err := tx.QueryContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			change := GenreNormalization{}
+
+			err := rows.Scan(&change.MovieID, pq.Array(&change.From))
+			if err != nil {
+				return err
+			}
+
+			for _, genre := range change.From {
+				code, ok := catalogue.Lookup(genre)
+				if !ok {
+					code = genre
+					change.Unknown = append(change.Unknown, genre)
+				}
+
+				// Leave out any genre which duplicates an earlier one once it has been converted to its code.
+				if !validator.PermittedValue(code, change.To...) {
+					change.To = append(change.To, code)
+				}
+			}
+
+			change.Changed = strings.Join(change.From, "\x00") != strings.Join(change.To, "\x00")
+
+			if change.Changed || len(change.Unknown) > 0 {
+				changes = append(changes, &change)
+			}
+		}
+
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		if dryRun {
+			return nil
+		}
+
+		query = `
+			UPDATE movies SET genres = $2, version = version + 1
+			WHERE id = $1
+			RETURNING id, title, year, runtime, genres, version`
+
+		for _, change := range changes {
+			if !change.Changed {
+				continue
+			}
+
+			var movie Movie
+
+			err := tx.QueryRowContext(ctx, query, change.MovieID, pq.Array(change.To)).Scan(
+				&movie.ID,
+				&movie.Title,
+				&movie.Year,
+				&movie.Runtime,
+				pq.Array(&movie.Genres),
+				&movie.Version,
+			)
+			if err != nil {
+				return err
+			}
+
+			err = insertMovieRevision(ctx, tx, &movie, 0)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
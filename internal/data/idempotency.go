@@ -0,0 +1,146 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"greenlight.luismatosgarcia.dev/internal/validator"
+	"net/http"
+	"time"
+)
+
+// IdempotentRequest holds a request that was made with an Idempotency-Key header. The Fingerprint identifies the
+// request itself, so that we can tell if the key is reused for a different request. Once the original request has
+// finished, the Status, Headers and Body hold the response that was sent, and Completed is true.
+type IdempotentRequest struct {
+	Key         string
+	UserID      int64
+	Fingerprint []byte
+	Completed   bool
+	Status      int
+	Headers     http.Header
+	Body        []byte
+}
+
+// IdempotencyModel wraps the connection pool for the idempotency_keys table.
+type IdempotencyModel struct {
+	DB DBTX
+}
+
+// ValidateIdempotencyKey checks that the Idempotency-Key header value is a sensible length.
+func ValidateIdempotencyKey(v *validator.Validator, key string) {
+	v.Check(key != "", "idempotency_key", "must be provided")
+	v.Check(len(key) <= 255, "idempotency_key", "must not be more than 255 bytes long")
+}
+
+// Claim records that a request with the given key is being processed for the user, so that any retries wait for its
+// response instead of running the request a second time. If the key is free (or the previous request with it has
+// expired), Claim returns nil and the caller should go ahead with the request. Otherwise, it returns the existing
+// request, which may or may not have completed yet.
+func (m IdempotencyModel) Claim(key string, userID int64, fingerprint []byte, ttl time.Duration) (*IdempotentRequest, error) {
+	// Insert a new row for the key. If there is already a row for it which has expired, we take it over instead. If
+	// there is a row which hasn't expired then nothing is inserted or updated, and no row is returned.
+	query := `
+		INSERT INTO idempotency_keys (key, user_id, fingerprint, expiry)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key, user_id) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint, status = NULL, headers = NULL, body = NULL,
+			created_at = now(), expiry = EXCLUDED.expiry
+		WHERE idempotency_keys.expiry < now()
+		RETURNING key`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var claimed string
+
+	err := m.DB.QueryRowContext(ctx, query, key, userID, fingerprint, time.Now().Add(ttl)).Scan(&claimed)
+	switch {
+	case err == nil:
+		return nil, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	// The key is already in use, so read the existing request.
+	query = `
+		SELECT fingerprint, status, headers, body
+		FROM idempotency_keys
+		WHERE key = $1 AND user_id = $2`
+
+	request := IdempotentRequest{Key: key, UserID: userID}
+
+	var status sql.NullInt32
+	var headers []byte
+
+	err = m.DB.QueryRowContext(ctx, query, key, userID).Scan(&request.Fingerprint, &status, &headers, &request.Body)
+	if err != nil {
+		switch {
+		// The row was released between our two queries, so the caller can simply try again.
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	if status.Valid {
+		request.Completed = true
+		request.Status = int(status.Int32)
+
+		err = json.Unmarshal(headers, &request.Headers)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &request, nil
+}
+
+// Complete stores the response for a claimed key, so that it can be replayed to any retries.
+func (m IdempotencyModel) Complete(request *IdempotentRequest) error {
+	headers, err := json.Marshal(request.Headers)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE idempotency_keys SET status = $1, headers = $2, body = $3
+		WHERE key = $4 AND user_id = $5`
+
+	args := []any{request.Status, headers, request.Body, request.Key, request.UserID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Release deletes a claimed key without storing a response, so that the request can be retried from scratch. We use
+// this when the original request failed with a server error.
+func (m IdempotencyModel) Release(key string, userID int64) error {
+	query := `DELETE FROM idempotency_keys WHERE key = $1 AND user_id = $2 AND status IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key, userID)
+	return err
+}
+
+// DeleteExpired removes all the keys whose replay window has passed, returning the number of rows deleted.
+func (m IdempotencyModel) DeleteExpired() (int64, error) {
+	query := `DELETE FROM idempotency_keys WHERE expiry < now()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
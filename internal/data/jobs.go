@@ -0,0 +1,271 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+// The statuses of a background job.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead"
+)
+
+// Job is an entry in the background job queue. The Kind picks the handler which runs the job, and the Payload is the
+// JSON-encoded input for it. Jobs which fail are retried until they have been attempted MaxAttempts times, after which
+// they are dead: they stay in the queue, with the error from the last attempt, until someone retries them by hand.
+type Job struct {
+	ID          int64           `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+// JobModel wraps the connection pool for the jobs table. Jobs can be enqueued through the Models passed to a WithTx()
+// function, so that they are only queued if the rest of the transaction is committed.
+type JobModel struct {
+	DB DBTX
+}
+
+// Enqueue adds a job to the queue to be run as soon as a worker is free. The payload is encoded as JSON.
+func (m JobModel) Enqueue(kind string, payload any, maxAttempts int) (*Job, error) {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		Kind:        kind,
+		Payload:     js,
+		Status:      JobQueued,
+		MaxAttempts: maxAttempts,
+	}
+
+	query := `
+		INSERT INTO jobs (kind, payload, max_attempts)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, run_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, kind, []byte(js), maxAttempts).Scan(&job.ID, &job.CreatedAt, &job.RunAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// Claim picks the oldest due job of one of the given kinds, marks it as running and counts the attempt. The job is
+// leased to the caller for the given duration, after which it can be claimed again. FOR UPDATE SKIP LOCKED lets any
+// number of workers (in this process or others) claim jobs at the same time without waiting on each other or getting
+// the same job. It returns an ErrRecordNotFound error if there aren't any jobs due.
+func (m JobModel) Claim(kinds []string, lease time.Duration) (*Job, error) {
+	query := `
+		UPDATE jobs SET status = 'running', attempts = attempts + 1, run_at = now() + make_interval(secs => $2)
+		WHERE id = (
+			SELECT id
+			FROM jobs
+			WHERE status IN ('queued', 'running') AND run_at <= now() AND kind = ANY($1)
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, created_at, kind, payload, status, attempts, max_attempts, run_at, last_error`
+
+	var job Job
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, pq.Array(kinds), lease.Seconds()).Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.Kind,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LastError,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+// Complete marks a job which has been claimed as succeeded.
+func (m JobModel) Complete(job *Job) error {
+	query := `
+		UPDATE jobs SET status = 'succeeded', last_error = '', finished_at = now()
+		WHERE id = $1 AND status = 'running' AND attempts = $2`
+
+	return m.exec(query, job.ID, job.Attempts)
+}
+
+// Retry puts a job which has been claimed but failed back in the queue, to be run again at runAt.
+func (m JobModel) Retry(job *Job, runAt time.Time, lastError string) error {
+	query := `
+		UPDATE jobs SET status = 'queued', run_at = $3, last_error = $4
+		WHERE id = $1 AND status = 'running' AND attempts = $2`
+
+	return m.exec(query, job.ID, job.Attempts, runAt, lastError)
+}
+
+// Bury marks a job which has been claimed but failed as dead, so that it isn't run again.
+func (m JobModel) Bury(job *Job, lastError string) error {
+	query := `
+		UPDATE jobs SET status = 'dead', last_error = $3, finished_at = now()
+		WHERE id = $1 AND status = 'running' AND attempts = $2`
+
+	return m.exec(query, job.ID, job.Attempts, lastError)
+}
+
+// Revive puts a dead job back in the queue with its attempts reset, so that it is run again as if it was new. It
+// returns an ErrRecordNotFound error if there isn't a dead job with the ID.
+func (m JobModel) Revive(id int64) (*Job, error) {
+	query := `
+		UPDATE jobs SET status = 'queued', attempts = 0, run_at = now(), finished_at = NULL
+		WHERE id = $1 AND status = 'dead'
+		RETURNING id, created_at, kind, payload, status, attempts, max_attempts, run_at, last_error`
+
+	var job Job
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.Kind,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LastError,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+// GetAll returns a page of the jobs, optionally only those with the given status and kind.
+func (m JobModel) GetAll(status, kind string, filters Filters) ([]*Job, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, kind, payload, status, attempts, max_attempts, run_at, last_error,
+			finished_at
+		FROM jobs
+		WHERE (status = $1 OR $1 = '') AND (kind = $2 OR $2 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, kind, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	jobs := []*Job{}
+
+	for rows.Next() {
+		var job Job
+
+		err := rows.Scan(
+			&totalRecords,
+			&job.ID,
+			&job.CreatedAt,
+			&job.Kind,
+			&job.Payload,
+			&job.Status,
+			&job.Attempts,
+			&job.MaxAttempts,
+			&job.RunAt,
+			&job.LastError,
+			&job.FinishedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		jobs = append(jobs, &job)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return jobs, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// DeleteSucceededOlderThan removes the jobs which succeeded more than the retention period ago, and returns the number
+// of jobs that were removed. Dead jobs are kept until they are retried.
+func (m JobModel) DeleteSucceededOlderThan(retention time.Duration) (int64, error) {
+	query := `DELETE FROM jobs WHERE status = 'succeeded' AND finished_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// The exec() helper runs an update to a single claimed job. The update only matches the job if it is still running the
+// same attempt, so it returns an ErrRecordNotFound error if the lease ran out and another worker has claimed the job
+// since.
+func (m JobModel) exec(query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
@@ -12,21 +13,145 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// DBTX is the set of methods that our models use to run queries. It is satisfied by both the *sql.DB connection pool
+// and a *sql.Tx transaction, so the same model code can be used either on its own or as one step in a larger
+// transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 // Models Create a Models struct which wraps the MovieModel. We'll add other models to this, like a UserModel and
 // PermissionModel, as our build progresses.
 type Models struct {
-	Movies      MovieModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Movies         MovieModel
+	MovieRevisions MovieRevisionModel
+	Users          UserModel
+	Tokens         TokenModel
+	Permissions    PermissionModel
+	Idempotency    IdempotencyModel
+	People         PersonModel
+	Credits        CreditModel
+	Reviews        ReviewModel
+	Watchlists     WatchlistModel
+	Translations   TranslationModel
+	Genres         GenreModel
+	MovieChanges   MovieChangeModel
+	Webhooks       WebhookModel
+	Deliveries     WebhookDeliveryModel
+	Jobs           JobModel
+	Suppressions   SuppressionModel
+
+	// db holds the connection pool, which WithTx() uses to begin new transactions.
+	db *sql.DB
+
+	// movieChanged is the hook set by OnMovieChange(), which WithTx() passes on to the models bound to the
+	// transaction.
+	movieChanged movieChangeHook
+}
+
+// A movieChangeHook is called after any write which changes what the movie read methods return, such as a movie update,
+// a new review (which changes the rating aggregates) or a translated title. It is nil unless OnMovieChange() has been
+// called. Write methods call it (via notify()) whether or not the write succeeded, as an unneeded call is harmless.
+type movieChangeHook func()
+
+// The notify() method calls the hook, if there is one.
+func (h movieChangeHook) notify() {
+	if h != nil {
+		h()
+	}
 }
 
 // For ease of use, we also add a New() method which returns a Models struct containing the initialized MovieModel.
 func NewModels(db *sql.DB) Models {
+	models := newModels(db, nil)
+	models.db = db
+	return models
+}
+
+// The newModels() function returns a Models struct where every model runs its queries using the given DBTX, and calls
+// the given hook after changing movies.
+func newModels(db DBTX, movieChanged movieChangeHook) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
+		Movies:         MovieModel{DB: db, changed: movieChanged},
+		MovieRevisions: MovieRevisionModel{DB: db},
+		Users:          UserModel{DB: db},
+		Tokens:         TokenModel{DB: db},
+		Permissions:    PermissionModel{DB: db},
+		Idempotency:    IdempotencyModel{DB: db},
+		People:         PersonModel{DB: db, changed: movieChanged},
+		Credits:        CreditModel{DB: db, changed: movieChanged},
+		Reviews:        ReviewModel{DB: db, changed: movieChanged},
+		Watchlists:     WatchlistModel{DB: db, changed: movieChanged},
+		Translations:   TranslationModel{DB: db, changed: movieChanged},
+		Genres:         GenreModel{DB: db, changed: movieChanged},
+		MovieChanges:   MovieChangeModel{DB: db},
+		Webhooks:       WebhookModel{DB: db},
+		Deliveries:     WebhookDeliveryModel{DB: db},
+		Jobs:           JobModel{DB: db},
+		Suppressions:   SuppressionModel{DB: db},
+		movieChanged:   movieChanged,
+	}
+}
+
+// OnMovieChange sets a function to be called after every write which changes what the movie read methods return, so
+// that anything which caches movies (like the API's response cache) can drop its copies. Writes made inside WithTx()
+// also call it once the transaction has been committed, as until then other connections still see the old data.
+func (m *Models) OnMovieChange(fn func()) {
+	db := m.db
+
+	*m = newModels(db, fn)
+	m.db = db
+}
+
+// WithTx runs fn inside a single database transaction. The Models struct passed to fn has every model bound to the
+// transaction, so all the queries that fn makes through it succeed or fail together. If fn returns an error (or panics)
+// the transaction is rolled back and the error is returned, otherwise the transaction is committed.
+func (m Models) WithTx(fn func(tx Models) error) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
 	}
+
+	// Roll back the transaction if we return early. Calling Rollback() after Commit() is a no-op.
+	defer tx.Rollback()
+
+	err = fn(newModels(tx, m.movieChanged))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	m.movieChanged.notify()
+
+	return nil
+}
+
+// The withTx() helper runs fn inside a transaction on db. If db is already a transaction then fn simply runs as part
+// of it, and it is left to whoever began the transaction to commit it. This lets model methods which need a
+// transaction of their own (such as writing a movie together with its revision) also be used from within WithTx().
+func withTx(ctx context.Context, db DBTX, fn func(tx DBTX) error) error {
+	pool, ok := db.(*sql.DB)
+	if !ok {
+		return fn(db)
+	}
+
+	tx, err := pool.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
@@ -7,25 +7,188 @@ import (
 	"fmt"
 	"github.com/lib/pq"
 	"greenlight.luismatosgarcia.dev/internal/validator"
+	"strings"
 	"time"
 )
 
 type Movie struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`
-	Runtime   Runtime   `json:"runtime,omitempty,string"`
-	Genres    []string  `json:"genres,omitempty"`
-	Version   int32     `json:"version"`
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"-"`
+	Title     string     `json:"title"`
+	Year      int32      `json:"year,omitempty"`
+	Runtime   Runtime    `json:"runtime,omitempty,string"`
+	Genres    []string   `json:"genres,omitempty"`
+	Version   int32      `json:"version"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// AverageRating and RatingCount are aggregated from the movie's reviews. They are kept up to date by the
+	// ReviewModel and can't be changed directly.
+	AverageRating float64 `json:"average_rating"`
+	RatingCount   int32   `json:"rating_count"`
+
+	// OriginalTitle and GenreNames are only filled in when the movie is localized for a client. OriginalTitle holds the
+	// canonical title if Title was replaced with a localized one, and GenreNames maps genre codes to their localized
+	// names.
+	OriginalTitle string            `json:"original_title,omitempty"`
+	GenreNames    map[string]string `json:"genre_names,omitempty"`
+
+	// Poster holds the URLs of the movie's poster image, keyed by size. It is nil if the movie doesn't have a poster.
+	Poster       map[string]string `json:"poster,omitempty"`
+	posterFormat string
+}
+
+// PosterSizes holds the width in pixels of each of the poster thumbnails that we generate, keyed by the size name
+// used in the size query string parameter.
+var PosterSizes = map[string]int{"small": 185, "medium": 342, "large": 780}
+
+// The setPoster() method fills in the Poster URLs from the poster_format column, once the movie has been scanned.
+func (movie *Movie) setPoster() {
+	if movie.posterFormat == "" {
+		movie.Poster = nil
+		return
+	}
+
+	movie.Poster = map[string]string{"original": fmt.Sprintf("/v1/movies/%d/poster", movie.ID)}
+
+	for size := range PosterSizes {
+		movie.Poster[size] = fmt.Sprintf("/v1/movies/%d/poster?size=%s", movie.ID, size)
+	}
+}
+
+// MovieFieldSafeList holds the movie fields that a client is allowed to ask for using the fields query string
+// parameter. Each entry is both the key used in the JSON output and the name of the matching column in the movies
+// table.
+var MovieFieldSafeList = []string{"id", "title", "year", "runtime", "genres", "version", "average_rating", "rating_count"}
+
+// defaultMovieColumns holds the columns which are selected when the client doesn't ask for specific fields.
+var defaultMovieColumns = []string{
+	"id", "created_at", "title", "year", "runtime", "genres", "version", "average_rating", "rating_count", "poster_format",
+}
+
+// movieColumns returns the columns to select for the requested fields. Just like the sortColumn() method on Filters,
+// we panic if a field isn't in the safelist, as it is interpolated directly into the SQL query and should have
+// already been checked by ValidateFields().
+func movieColumns(fields []string) []string {
+	if len(fields) == 0 {
+		return defaultMovieColumns
+	}
+
+	for _, field := range fields {
+		if !validator.PermittedValue(field, MovieFieldSafeList...) {
+			panic("unsafe fields parameter: " + field)
+		}
+	}
+
+	return fields
+}
+
+// scanDest returns the scan destinations in the movie struct for the given columns, in the same order.
+func (movie *Movie) scanDest(columns []string) []any {
+	dest := make([]any, len(columns))
+
+	for i, column := range columns {
+		switch column {
+		case "id":
+			dest[i] = &movie.ID
+		case "created_at":
+			dest[i] = &movie.CreatedAt
+		case "title":
+			dest[i] = &movie.Title
+		case "year":
+			dest[i] = &movie.Year
+		case "runtime":
+			dest[i] = &movie.Runtime
+		case "genres":
+			dest[i] = pq.Array(&movie.Genres)
+		case "version":
+			dest[i] = &movie.Version
+		case "deleted_at":
+			dest[i] = &movie.DeletedAt
+		case "average_rating":
+			dest[i] = &movie.AverageRating
+		case "rating_count":
+			dest[i] = &movie.RatingCount
+		case "poster_format":
+			dest[i] = &movie.posterFormat
+		default:
+			panic("unknown movie column: " + column)
+		}
+	}
+
+	return dest
+}
+
+// formattedMovie is the JSON representation of a whole movie with its runtime in a non-default format. The Runtime
+// field is shallower than the embedded movie's, so it takes its place when encoding.
+type formattedMovie struct {
+	*Movie
+	Runtime any `json:"runtime,omitempty"`
+}
+
+// Project returns a representation of the movie which contains only the requested fields, with the runtime in the
+// requested format, ready to be encoded to JSON. If no fields or format were requested we return the movie itself, so
+// that the default output stays exactly the same.
+func (movie *Movie) Project(fields []string, format RuntimeFormat) any {
+	if len(fields) == 0 {
+		if format == RuntimeFormatDefault {
+			return movie
+		}
+		return formattedMovie{Movie: movie, Runtime: movie.Runtime.Format(format)}
+	}
+
+	projection := make(map[string]any, len(fields))
+
+	for _, field := range fields {
+		switch field {
+		case "id":
+			projection[field] = movie.ID
+		case "title":
+			projection[field] = movie.Title
+			if movie.OriginalTitle != "" {
+				projection["original_title"] = movie.OriginalTitle
+			}
+		case "year":
+			projection[field] = movie.Year
+		case "runtime":
+			projection[field] = movie.Runtime.Format(format)
+		case "genres":
+			projection[field] = movie.Genres
+			if movie.GenreNames != nil {
+				projection["genre_names"] = movie.GenreNames
+			}
+		case "version":
+			projection[field] = movie.Version
+		case "average_rating":
+			projection[field] = movie.AverageRating
+		case "rating_count":
+			projection[field] = movie.RatingCount
+		}
+	}
+
+	return projection
+}
+
+// ProjectMovies calls Project() on every movie in the slice.
+func ProjectMovies(movies []*Movie, fields []string, format RuntimeFormat) []any {
+	projections := make([]any, len(movies))
+
+	for i, movie := range movies {
+		projections[i] = movie.Project(fields, format)
+	}
+
+	return projections
 }
 
 // Define a MovieModel struct type which wraps a sql.DB connection pool.
 type MovieModel struct {
-	DB *sql.DB
+	DB      DBTX
+	changed movieChangeHook
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+// ValidateMovie checks the movie's fields. Each of the movie's genres must be in the genre catalogue, matched without
+// regard to case or whitespace (or by one of the genre's aliases), and the genres are replaced with their canonical
+// codes so that they are always stored the same way.
+func ValidateMovie(v *validator.Validator, movie *Movie, genres GenreCatalogue) {
 	// Use the Check() method to execute our validation checks. This will add the provided key and error message
 	// to the errors map if the check does not evaluate to true. For example, in the first line here we "check
 	// that the title is not equal to the empty string". In the second, we "check that the length os the title is
@@ -43,11 +206,30 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Genres != nil, "genres", "must be provided")
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
+
+	var unknown []string
+
+	for i, genre := range movie.Genres {
+		code, ok := genres.Lookup(genre)
+		if !ok {
+			unknown = append(unknown, genre)
+			continue
+		}
+		movie.Genres[i] = code
+	}
+
+	v.Check(len(unknown) == 0, "genres", fmt.Sprintf("must only contain known genres (unknown: %s)", strings.Join(unknown, ", ")))
+
+	// Check for duplicates after converting to the canonical codes, so that "Sci-Fi" and "science fiction" count as
+	// the same genre.
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
 }
 
-// The Insert() method accepts a pointer to a movie struct, should contain the data for the new record.
-func (m MovieModel) Insert(movie *Movie) error {
+// The Insert() method accepts a pointer to a movie struct, should contain the data for the new record, and the ID of
+// the user who is creating it. The first revision of the movie is recorded in the same transaction.
+func (m MovieModel) Insert(movie *Movie, userID int64) error {
+	defer m.changed.notify()
+
 	// Define the SQL query for inserting a new record in the movies table and returning the system-generated data.
 	query := `
 			INSERT INTO movies (title, year, runtime, genres)
@@ -64,13 +246,22 @@ func (m MovieModel) Insert(movie *Movie) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// Use the QueryRow() method to execute the SQL query on our connection pool, passing in the args lice as a
-	// variadic parameter and scanning the system-generated id, created_at and version values into the movie struct.
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	// Use a transaction, so that the movie and its first revision are stored together.
+	return withTx(ctx, m.DB, func(tx DBTX) error {
+		// Use the QueryRow() method to execute the SQL query, passing in the args lice as a variadic parameter and
+		// scanning the system-generated id, created_at and version values into the movie struct.
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+		if err != nil {
+			return err
+		}
 
+		return insertMovieRevision(ctx, tx, movie, userID)
+	})
 }
 
-func (m MovieModel) Get(id int64) (*Movie, error) {
+// Get fetches a specific movie. Optionally, the fields that should be selected can be passed in, in which case only
+// the matching columns are read from the database.
+func (m MovieModel) Get(id int64, fields ...string) (*Movie, error) {
 	// the PostgreSQL bigserial type that we're using for the movie ID starts auto-incrementing at 1 by default, so we
 	// know that no movies will have ID values less thant that. To avoid making an unnecessary database call, we take
 	// a shortcut and return an ErrRecordNotFound error straight way.
@@ -78,11 +269,15 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		return nil, ErrRecordNotFound
 	}
 
+	// Work out which columns we need to select.
+	columns := movieColumns(fields)
+
 	//Define the SQL query for retrieving the movie data.
-	query := `SELECT id, created_at, title, year, runtime, genres, version FROM movies WHERE id = $1`
+	query := fmt.Sprintf(`SELECT %s FROM movies WHERE id = $1 AND deleted_at IS NULL`, strings.Join(columns, ", "))
 
-	// Declare a Movie struct to hold the data returned by the query.
-	var movie Movie
+	// Declare a Movie struct to hold the data returned by the query. We already know the ID, so fill it in now in case
+	// the id column isn't one of the requested fields.
+	movie := Movie{ID: id}
 
 	// Use the context.WithTimeout() function to create a context.Context which carries a 3-second timeout deadline.
 	// Note that we're using the empty context.Background() as the 'parent' context.
@@ -96,15 +291,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	// the scan target for the genres column using the pq.Array() adapter function again.
 	// UPDATE - Use the QueryRowContext() method to execute the query, passing in the context with the deadline
 	// as the first argument.
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&movie.ID,
-		&movie.CreatedAt,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		pq.Array(&movie.Genres),
-		&movie.Version,
-	)
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(movie.scanDest(columns)...)
 
 	// Handle any errors. If there was no matching movie found, Scan() will return a sql.ErrNoRows error.
 	// We check for this and return our custom ErrRecordNotFound error instead.
@@ -117,14 +304,20 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		}
 	}
 
+	movie.setPoster()
+
 	// Otherwise, return a pointer to the Movie struct.
 	return &movie, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+// Update saves the changes to a movie, using the version number to check that the movie hasn't been changed since it
+// was read. The userID is the user making the change, and is stored with the new revision of the movie.
+func (m MovieModel) Update(movie *Movie, userID int64) error {
+	defer m.changed.notify()
+
 	// Declare the SQL query for updating the record and returning the new version number.
 	query := `UPDATE movies SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-       WHERE id = $5 AND version = $6
+       WHERE id = $5 AND version = $6 AND deleted_at IS NULL
        RETURNING version
      `
 
@@ -142,39 +335,140 @@ func (m MovieModel) Update(movie *Movie) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// Use the QueryRow() method to execute the query, passing in the args slice as a variadic parameter and
-	// scanning the new version value into the movie struct. Execute the SQL query. If no matching row could be found,
-	// we know the movie version has changed (or the record has been deleted) and we return our custom ErrEditConflict
-	// error.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	// Use a transaction, so that the new version and its revision are stored together.
+	return withTx(ctx, m.DB, func(tx DBTX) error {
+		// Use the QueryRow() method to execute the query, passing in the args slice as a variadic parameter and
+		// scanning the new version value into the movie struct. Execute the SQL query. If no matching row could be
+		// found, we know the movie version has changed (or the record has been deleted) and we return our custom
+		// ErrEditConflict error.
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		return insertMovieRevision(ctx, tx, movie, userID)
+	})
+}
+
+// Delete moves a movie to the trash. Rather than removing the row, we set its deleted_at column so that it can be
+// restored later. Deleted movies are hidden by all the other read methods, and are permanently removed by Purge()
+// once the retention period has passed. If version is greater than zero then the movie is only deleted if it still
+// has that version, and an ErrEditConflict error is returned if it doesn't.
+//
+// The movie is also taken off every user's watchlist, in the same transaction. These entries aren't brought back if
+// the movie is restored.
+func (m MovieModel) Delete(id int64, version int32) error {
+	defer m.changed.notify()
+
+	// Return an ErrRecordNotFound error if the movie ID is less than 1.
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	// Construct the SQL query to mark the record as deleted. Movies which are already in the trash are left alone,
+	// so that their deletion time isn't reset.
+	query := `UPDATE movies SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx DBTX) error {
+		result, err := tx.ExecContext(ctx, query, id, version)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			if version > 0 {
+				return ErrEditConflict
+			}
+			return ErrRecordNotFound
+		}
+
+		return removeFromWatchlists(ctx, tx, id)
+	})
+}
+
+// SetPoster records that the movie has a poster in the given image format ("jpeg", "png" or "gif"). The image files
+// themselves are kept in storage rather than in the database. Like the rating aggregates, a new poster doesn't change
+// the movie's version number.
+func (m MovieModel) SetPoster(id int64, format string) error {
+	defer m.changed.notify()
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `UPDATE movies SET poster_format = $2, poster_updated_at = now() WHERE id = $1 AND deleted_at IS NULL`
+
+	return m.execForID(query, id, format)
+}
+
+// GetPoster returns the image format of the movie's poster and when it was last changed. It returns an
+// ErrRecordNotFound error if the movie doesn't exist or doesn't have a poster.
+func (m MovieModel) GetPoster(id int64) (string, time.Time, error) {
+	if id < 1 {
+		return "", time.Time{}, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT poster_format, poster_updated_at
+		FROM movies
+		WHERE id = $1 AND deleted_at IS NULL AND poster_format <> ''`
+
+	var format string
+	var updatedAt time.Time
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&format, &updatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
+			return "", time.Time{}, ErrRecordNotFound
 		default:
-			return err
+			return "", time.Time{}, err
 		}
 	}
 
-	return nil
+	return format, updatedAt, nil
 }
 
-func (m MovieModel) Delete(id int64) error {
-	// Return an ErrRecordNotFound error if the movie ID is less than 1.
+// Restore takes a movie out of the trash, returning an ErrRecordNotFound error if there isn't a deleted movie with the
+// provided ID.
+func (m MovieModel) Restore(id int64) error {
+	defer m.changed.notify()
+
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	// Construct the SQL query to delete the record.
-	query := `DELETE FROM movies WHERE id = $1`
+	query := `UPDATE movies SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL`
+
+	return m.execForID(query, id)
+}
 
+// The execForID() helper executes a query which affects a single movie, returning an ErrRecordNotFound error if no
+// rows were affected. The movie ID is always the first placeholder parameter, followed by any extra args.
+func (m MovieModel) execForID(query string, id int64, args ...any) error {
 	// Create a context with 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	// Execute the SQL query using the Exec() method, passing in the id variable as the value for the placeholder
 	// parameter. The Exec() method returns a sql.Result object.
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, append([]any{id}, args...)...)
 	if err != nil {
 		return err
 	}
@@ -185,25 +479,101 @@ func (m MovieModel) Delete(id int64) error {
 		return err
 	}
 
-	// If no rows were affected, we know that the movies table didn't contain a record with the provided ID at the
-	// moment we tried to delete it. In that case we return an ErrRecordNotFound error.
+	// If no rows were affected, we know that the movies table didn't contain a matching record at the moment we
+	// ran the query. In that case we return an ErrRecordNotFound error.
 	if rowsAffected == 0 {
 		return ErrRecordNotFound
 	}
 
 	return nil
+}
+
+// Purge permanently deletes the movies which were moved to the trash more than the retention period ago, and returns
+// the number of movies that were removed.
+func (m MovieModel) Purge(retention time.Duration) (int64, error) {
+	defer m.changed.notify()
+
+	query := `DELETE FROM movies WHERE deleted_at IS NOT NULL AND deleted_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// GetAllDeleted returns a page of the movies which are currently in the trash.
+func (m MovieModel) GetAllDeleted(filters Filters) ([]*Movie, Metadata, error) {
+	columns := []string{"id", "created_at", "title", "year", "runtime", "genres", "version", "deleted_at"}
+
+	query := fmt.Sprintf(`SELECT count(*) OVER(), %s
+          FROM movies
+          WHERE deleted_at IS NOT NULL
+          ORDER BY %s %s, id ASC
+          LIMIT $1 OFFSET $2`, strings.Join(columns, ", "), filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(append([]any{&totalRecords}, movie.scanDest(columns)...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return movies, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
 }
 
 // GetAll Create a new GetAll() method which returns a slice of movies. Although we're not using them right now, we've // set this up to accept the various filter parameters as arguments.
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+//
+// The title search matches both the canonical title and any of the movie's localized titles.
+//
+// If personID is greater than zero, only the movies which credit that person (in any role) are returned. Likewise, if
+// watchlistUserID is greater than zero, only the movies on that user's watchlist are returned.
+func (m MovieModel) GetAll(title string, genres []string, personID, watchlistUserID int64, filters Filters) ([]*Movie, Metadata, error) {
+	// Work out which columns the client asked for.
+	columns := movieColumns(filters.Fields)
+
 	// Construct the SQL query to retrieve all movie records.
-	query := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+	query := fmt.Sprintf(`SELECT count(*) OVER(), %s
           FROM movies
-          WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+          WHERE deleted_at IS NULL
+          AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '' OR EXISTS (
+              SELECT 1 FROM movie_titles
+              WHERE movie_titles.movie_id = movies.id
+              AND to_tsvector('simple', movie_titles.title) @@ plainto_tsquery('simple', $1)
+          ))
           AND (genres @> $2 OR $2 = '{}')
+          AND ($5 = 0 OR EXISTS (
+              SELECT 1 FROM movie_credits WHERE movie_credits.movie_id = movies.id AND movie_credits.person_id = $5
+          ))
+          AND ($6 = 0 OR EXISTS (
+              SELECT 1 FROM watchlist_entries WHERE watchlist_entries.movie_id = movies.id AND watchlist_entries.user_id = $6
+          ))
           ORDER BY %s %s, id ASC
-          LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+          LIMIT $3 OFFSET $4`, strings.Join(columns, ", "), filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -211,7 +581,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	// As our SQL query now has quite a few placeholder parameters, let's collect the values for the placeholders in a
 	// slice. Notice here how we call the limit() and offset() methods on the Filters struct to get the appropriate
 	// values for the LIMIT and OFFSET clauses.
-	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
+	args := []any{title, pq.Array(genres), filters.limit(), filters.offset(), personID, watchlistUserID}
 
 	//Pass the title and genres as the placeholder parameter values.
 	rows, err := m.DB.QueryContext(ctx, query, args...)
@@ -234,21 +604,14 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 
 		// Scan the values from the row into the Movie struct. Again, note that we're using the pq.Array() adapter
 		// on the genres field here.
-		err := rows.Scan(
-			&totalRecords,
-			&movie.ID,
-			&movie.CreatedAt,
-			&movie.Title,
-			&movie.Year,
-			&movie.Runtime,
-			pq.Array(&movie.Genres),
-			&movie.Version,
-		)
+		err := rows.Scan(append([]any{&totalRecords}, movie.scanDest(columns)...)...)
 
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
+		movie.setPoster()
+
 		// Add the Movie struct to the slice
 		movies = append(movies, &movie)
 	}
@@ -265,3 +628,118 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	// If everything went OK, then return the slice of movies.
 	return movies, metadata, nil
 }
+
+// InsertMany inserts all the provided movies in a single transaction, recording userID as the author of their first
+// revisions. Rather than running a separate INSERT statement for every movie, we use the PostgreSQL COPY protocol
+// (via the pq.CopyIn() helper) to load all the rows into a temporary table in one go, and then move them into the
+// movies table with a single statement which also writes the revisions. Either all the movies are inserted, or none
+// of them are.
+func (m MovieModel) InsertMany(movies []*Movie, userID int64) error {
+	defer m.changed.notify()
+
+	// Create a context with a 30-second timeout. Copying a large catalogue can take longer than a single insert, so
+	// we allow more time than usual.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// COPY is only allowed inside a transaction, so run everything inside one.
+	return withTx(ctx, m.DB, func(tx DBTX) error {
+		// Create the temporary table that we copy the rows into. It is dropped automatically when the transaction
+		// ends.
+		_, err := tx.ExecContext(ctx, `
+			CREATE TEMPORARY TABLE movies_import (
+				position integer NOT NULL,
+				title text NOT NULL,
+				year integer NOT NULL,
+				runtime integer NOT NULL,
+				genres text[] NOT NULL
+			) ON COMMIT DROP`)
+		if err != nil {
+			return err
+		}
+
+		stmt, err := tx.PrepareContext(ctx, pq.CopyIn("movies_import", "position", "title", "year", "runtime", "genres"))
+		if err != nil {
+			return err
+		}
+
+		// Each call to ExecContext() buffers a row, and the data is only sent to the database once the buffer is
+		// full or the statement is flushed by calling ExecContext() with no arguments.
+		for i, movie := range movies {
+			_, err = stmt.ExecContext(ctx, i, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres))
+			if err != nil {
+				stmt.Close()
+				return err
+			}
+		}
+
+		_, err = stmt.ExecContext(ctx)
+		if err != nil {
+			stmt.Close()
+			return err
+		}
+
+		err = stmt.Close()
+		if err != nil {
+			return err
+		}
+
+		// Move the rows into the movies table, keeping the order that they were sent in, and record the first
+		// revision for each of the new movies.
+		query := `
+			WITH inserted AS (
+				INSERT INTO movies (title, year, runtime, genres)
+				SELECT title, year, runtime, genres FROM movies_import ORDER BY position
+				RETURNING id, version, title, year, runtime, genres
+			)
+			INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres, user_id)
+			SELECT id, version, title, year, runtime, genres, $1 FROM inserted`
+
+		_, err = tx.ExecContext(ctx, query, userID)
+		return err
+	})
+}
+
+// Stream calls fn once for every movie matching the title and genres filters, in the order given by the filters
+// sort value. Unlike GetAll() the results are not paginated, and the rows are read from the database one at a
+// time, so the whole catalogue never needs to be held in memory. If fn returns an error then streaming stops and
+// that error is returned.
+//
+// As a large export can take a long time to write to the client, there is no fixed timeout. Instead the query runs
+// until ctx is cancelled, which for an export is when the client goes away.
+func (m MovieModel) Stream(ctx context.Context, title string, genres []string, filters Filters, fn func(*Movie) error) error {
+	query := fmt.Sprintf(`SELECT %s
+          FROM movies
+          WHERE deleted_at IS NULL
+          AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '' OR EXISTS (
+              SELECT 1 FROM movie_titles
+              WHERE movie_titles.movie_id = movies.id
+              AND to_tsvector('simple', movie_titles.title) @@ plainto_tsquery('simple', $1)
+          ))
+          AND (genres @> $2 OR $2 = '{}')
+          ORDER BY %s %s, id ASC`, strings.Join(defaultMovieColumns, ", "), filters.sortColumn(), filters.sortDirection())
+
+	rows, err := m.DB.QueryContext(ctx, query, title, pq.Array(genres))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(movie.scanDest(defaultMovieColumns)...)
+		if err != nil {
+			return err
+		}
+
+		movie.setPoster()
+
+		err = fn(&movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
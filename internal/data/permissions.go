@@ -2,7 +2,6 @@ package data
 
 import (
 	"context"
-	"database/sql"
 	"github.com/lib/pq"
 	"time"
 )
@@ -24,7 +23,7 @@ func (p Permissions) Include(code string) bool {
 
 // PermissionModel - Define the PermissionModel type.
 type PermissionModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 // GetAllForUser - The GetAllForUser() method returns all permission codes for a specific user in a Permissions slice. The code in
@@ -0,0 +1,264 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight.luismatosgarcia.dev/internal/validator"
+	"time"
+)
+
+// ErrDuplicateReview is returned when a user tries to review a movie that they have already reviewed.
+var ErrDuplicateReview = errors.New("duplicate review")
+
+// Review holds a single user's rating of a movie, with an optional written review. Each user can review a movie only
+// once, and after that they can edit or delete their review.
+type Review struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	Score     int32     `json:"score"`
+	Body      string    `json:"body,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+// ReviewModel wraps the connection pool for the reviews table.
+type ReviewModel struct {
+	DB      DBTX
+	changed movieChangeHook
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Score != 0, "score", "must be provided")
+	v.Check(review.Score >= 1 && review.Score <= 10, "score", "must be between 1 and 10")
+
+	v.Check(len(review.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+// Insert adds a new review and updates the movie's rating aggregates in the same transaction. It returns an
+// ErrRecordNotFound error if the movie doesn't exist, and an ErrDuplicateReview error if the user has already
+// reviewed it.
+func (m ReviewModel) Insert(review *Review) error {
+	defer m.changed.notify()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx DBTX) error {
+		err := lockMovieForRating(ctx, tx, review.MovieID)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			INSERT INTO reviews (movie_id, user_id, score, body)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at, version`
+
+		args := []any{review.MovieID, review.UserID, review.Score, review.Body}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.Version)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "reviews_movie_id_user_id_key"`:
+				return ErrDuplicateReview
+			default:
+				return err
+			}
+		}
+
+		return updateMovieRating(ctx, tx, review.MovieID)
+	})
+}
+
+// Get returns a specific review of a movie, or an ErrRecordNotFound error if there isn't one.
+func (m ReviewModel) Get(movieID, id int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, movie_id, user_id, score, body, version
+		FROM reviews
+		WHERE id = $1 AND movie_id = $2`
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, movieID).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.Score,
+		&review.Body,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// Update saves the changes to a review and updates the movie's rating aggregates, using the version number to check
+// for edit conflicts.
+func (m ReviewModel) Update(review *Review) error {
+	defer m.changed.notify()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx DBTX) error {
+		err := lockMovieForRating(ctx, tx, review.MovieID)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			UPDATE reviews SET score = $1, body = $2, version = version + 1
+			WHERE id = $3 AND version = $4
+			RETURNING version`
+
+		args := []any{review.Score, review.Body, review.ID, review.Version}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&review.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		return updateMovieRating(ctx, tx, review.MovieID)
+	})
+}
+
+// Delete removes a review and updates the movie's rating aggregates.
+func (m ReviewModel) Delete(movieID, id int64) error {
+	defer m.changed.notify()
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx DBTX) error {
+		err := lockMovieForRating(ctx, tx, movieID)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, `DELETE FROM reviews WHERE id = $1 AND movie_id = $2`, id, movieID)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+
+		return updateMovieRating(ctx, tx, movieID)
+	})
+}
+
+// GetAllForMovie returns a page of the reviews for a movie.
+func (m ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, movie_id, user_id, score, body, version
+		FROM reviews
+		WHERE movie_id = $1
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.Score,
+			&review.Body,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return reviews, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// lockMovieForRating takes a row lock on the movie before one of its reviews is changed. This serializes the review
+// writes for each movie, so when updateMovieRating() runs it always sees every review committed before it, and two
+// concurrent writes can't each recalculate the aggregates without the other's change. It returns an
+// ErrRecordNotFound error if the movie doesn't exist or has been deleted.
+func lockMovieForRating(ctx context.Context, tx DBTX, movieID int64) error {
+	query := `SELECT id FROM movies WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+
+	err := tx.QueryRowContext(ctx, query, movieID).Scan(&movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateMovieRating recalculates the average_rating and rating_count columns for a movie from its reviews. The
+// aggregates are derived data rather than an edit to the movie, so the movie's version number is left alone.
+func updateMovieRating(ctx context.Context, tx DBTX, movieID int64) error {
+	query := `
+		UPDATE movies SET (average_rating, rating_count) = (
+			SELECT COALESCE(round(avg(score), 2), 0), count(*)
+			FROM reviews
+			WHERE movie_id = $1
+		)
+		WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, query, movieID)
+	return err
+}
@@ -0,0 +1,167 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"reflect"
+	"time"
+)
+
+// MovieRevision holds a copy of a movie as it was at a specific version, along with the ID of the user who made the
+// change. The UserID is nil if the revision pre-dates revision tracking, or if the user has since been deleted.
+type MovieRevision struct {
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+	Title     string    `json:"title"`
+	Year      int32     `json:"year"`
+	Runtime   Runtime   `json:"runtime"`
+	Genres    []string  `json:"genres"`
+	UserID    *int64    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RevisionChange describes how a single field changed between two revisions.
+type RevisionChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// MovieRevisionModel wraps the connection pool for reading movie revisions. Revisions are written by the MovieModel,
+// in the same transaction as the change to the movie itself.
+type MovieRevisionModel struct {
+	DB DBTX
+}
+
+// insertMovieRevision records the current state of the movie as a new revision. It must be called inside the
+// transaction which created or updated the movie, so that a movie version is never stored without its revision. A
+// userID of zero is used for changes made by the system rather than a user, and is stored as NULL.
+func insertMovieRevision(ctx context.Context, tx DBTX, movie *Movie, userID int64) error {
+	query := `
+		INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres, user_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	user := sql.NullInt64{Int64: userID, Valid: userID > 0}
+
+	args := []any{movie.ID, movie.Version, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), user}
+
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetAllForMovie returns a page of the revisions for a specific movie.
+func (m MovieRevisionModel) GetAllForMovie(movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), movie_id, version, title, year, runtime, genres, user_id, created_at
+		FROM movie_revisions
+		WHERE movie_id = $1
+		ORDER BY %s %s
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var revision MovieRevision
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.Title,
+			&revision.Year,
+			&revision.Runtime,
+			pq.Array(&revision.Genres),
+			&revision.UserID,
+			&revision.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return revisions, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Get returns a specific revision of a movie, or an ErrRecordNotFound error if it doesn't exist.
+func (m MovieRevisionModel) Get(movieID int64, version int32) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT movie_id, version, title, year, runtime, genres, user_id, created_at
+		FROM movie_revisions
+		WHERE movie_id = $1 AND version = $2`
+
+	var revision MovieRevision
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(
+		&revision.MovieID,
+		&revision.Version,
+		&revision.Title,
+		&revision.Year,
+		&revision.Runtime,
+		pq.Array(&revision.Genres),
+		&revision.UserID,
+		&revision.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
+
+// DiffRevisions returns the fields which differ between two revisions of a movie, keyed by their JSON name. If from is
+// nil (because to is the first revision), every field is reported as changed.
+func DiffRevisions(from, to *MovieRevision) map[string]RevisionChange {
+	if from == nil {
+		from = &MovieRevision{}
+	}
+
+	changes := make(map[string]RevisionChange)
+
+	if from.Title != to.Title {
+		changes["title"] = RevisionChange{From: from.Title, To: to.Title}
+	}
+
+	if from.Year != to.Year {
+		changes["year"] = RevisionChange{From: from.Year, To: to.Year}
+	}
+
+	if from.Runtime != to.Runtime {
+		changes["runtime"] = RevisionChange{From: from.Runtime, To: to.Runtime}
+	}
+
+	if !reflect.DeepEqual(from.Genres, to.Genres) {
+		changes["genres"] = RevisionChange{From: from.Genres, To: to.Genres}
+	}
+
+	return changes
+}
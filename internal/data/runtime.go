@@ -3,17 +3,143 @@ package data
 import (
 	"errors"
 	"fmt"
+	"math"
+	"regexp"
 	"strconv"
 	"strings"
 )
 
 // Define an error that our UnmarshalJSON() method can return if we're unable to parse or convert the JSON string
-// successfully
+// successfully. The errors that we actually return wrap this one with details of the formats that we accept, so check
+// for it with errors.Is().
 var ErrInvalidRuntimeFormat = errors.New("invalid runtime format")
 
+// acceptedRuntimeFormats describes the runtime formats that ParseRuntime() understands, for use in error messages.
+const acceptedRuntimeFormats = `a number of minutes (102 or "102 mins"), hours and minutes ("1h 42m") or an ISO 8601 duration ("PT1H42M")`
+
+var (
+	// humanRuntimeRX matches runtimes written as hours and/or minutes, like "102 mins", "1h 42m", "1h42m" or "2 hours".
+	humanRuntimeRX = regexp.MustCompile(`^(?:(\d+)\s*(?:h|hr|hrs|hour|hours))?\s*(?:(\d+)\s*(?:m|min|mins|minute|minutes))?$`)
+
+	// isoRuntimeRX matches ISO 8601 durations made up of hours, minutes and seconds, like "PT1H42M".
+	isoRuntimeRX = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?$`)
+)
+
 // Runtime Declare a custom Runtime type, which has the underlying type int32 (the sames as our Movie struct field).
 type Runtime int32
 
+// RuntimeFormat selects how a runtime is written out in JSON. The default (empty) format is the original
+// "<runtime> mins" string.
+type RuntimeFormat string
+
+const (
+	RuntimeFormatDefault RuntimeFormat = ""
+	RuntimeFormatMinutes RuntimeFormat = "minutes"
+	RuntimeFormatHuman   RuntimeFormat = "human"
+	RuntimeFormatISO8601 RuntimeFormat = "iso8601"
+)
+
+// RuntimeFormats holds the names of the formats that a client can ask for.
+var RuntimeFormats = []string{string(RuntimeFormatMinutes), string(RuntimeFormatHuman), string(RuntimeFormatISO8601)}
+
+// ParseRuntime converts a string into a Runtime. It accepts a plain number of minutes ("102"), hours and minutes in
+// several common spellings ("102 mins", "1h 42m", "1 hour 42 minutes") and ISO 8601 durations ("PT1H42M"). ISO 8601
+// durations with seconds are only accepted if they come to a whole number of minutes.
+func ParseRuntime(s string) (Runtime, error) {
+	s = strings.TrimSpace(s)
+
+	invalid := fmt.Errorf("%w: %q must be %s", ErrInvalidRuntimeFormat, s, acceptedRuntimeFormats)
+
+	if s == "" {
+		return 0, invalid
+	}
+
+	var minutes int64
+
+	switch upper := strings.ToUpper(s); {
+	case strings.HasPrefix(upper, "PT"):
+		matches := isoRuntimeRX.FindStringSubmatch(upper)
+		if matches == nil || upper == "PT" {
+			return 0, invalid
+		}
+
+		hours, _ := strconv.ParseInt("0"+matches[1], 10, 64)
+		mins, _ := strconv.ParseInt("0"+matches[2], 10, 64)
+		seconds, _ := strconv.ParseInt("0"+matches[3], 10, 64)
+
+		if seconds%60 != 0 {
+			return 0, fmt.Errorf("%w: %q must be a whole number of minutes", ErrInvalidRuntimeFormat, s)
+		}
+
+		minutes = hours*60 + mins + seconds/60
+
+	default:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			minutes = n
+			break
+		}
+
+		matches := humanRuntimeRX.FindStringSubmatch(strings.ToLower(s))
+		if matches == nil || (matches[1] == "" && matches[2] == "") {
+			return 0, invalid
+		}
+
+		hours, _ := strconv.ParseInt("0"+matches[1], 10, 64)
+		mins, _ := strconv.ParseInt("0"+matches[2], 10, 64)
+
+		minutes = hours*60 + mins
+	}
+
+	if minutes > math.MaxInt32 || minutes < math.MinInt32 {
+		return 0, fmt.Errorf("%w: %q is too long", ErrInvalidRuntimeFormat, s)
+	}
+
+	return Runtime(minutes), nil
+}
+
+// Format returns the runtime in the given format, ready to be encoded to JSON. The minutes format is a JSON number,
+// and the others are strings.
+func (r Runtime) Format(format RuntimeFormat) any {
+	switch format {
+	case RuntimeFormatMinutes:
+		return int32(r)
+	case RuntimeFormatHuman:
+		return r.human()
+	case RuntimeFormatISO8601:
+		return r.iso8601()
+	default:
+		return r
+	}
+}
+
+// The human() method returns the runtime as hours and minutes, like "1h 42m", leaving out a part if it is zero.
+func (r Runtime) human() string {
+	hours, mins := r/60, r%60
+
+	switch {
+	case hours == 0:
+		return fmt.Sprintf("%dm", mins)
+	case mins == 0:
+		return fmt.Sprintf("%dh", hours)
+	default:
+		return fmt.Sprintf("%dh %dm", hours, mins)
+	}
+}
+
+// The iso8601() method returns the runtime as an ISO 8601 duration, like "PT1H42M".
+func (r Runtime) iso8601() string {
+	hours, mins := r/60, r%60
+
+	switch {
+	case hours == 0:
+		return fmt.Sprintf("PT%dM", mins)
+	case mins == 0:
+		return fmt.Sprintf("PT%dH", hours)
+	default:
+		return fmt.Sprintf("PT%dH%dM", hours, mins)
+	}
+}
+
 // MarshalJSON Implement a MarshalJSON() method on the Runtime type so that is satisfies the json.Marshaler interface.
 // This should return the JSON-encoded value for the movie runtime (in our case, it will return a string in the
 // format "<runtime> mins").
@@ -33,35 +159,41 @@ func (r Runtime) MarshalJSON() ([]byte, error) {
 // IMPORTANT: Because UnmarshalJSON() needs to modify the receiver (our Runtime type), we must use a pointer receiver
 // for this to work correctly. Otherwise, we will only be modifying a copy (which is then discarded when this method
 // returns).
+//
+// The runtime can either be a JSON number of minutes, or a string in any of the formats accepted by ParseRuntime().
 func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
-	// We expect that the incoming  JSON value will be a string in the format "<runtime> mins", and the first thing
-	// we need to do is remove the surrounding double-quotes from this string. If we can't unquote it, then
-	// we return the ErrInvalidRuntimeFormat error.
-	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
-	if err != nil {
-		return ErrInvalidRuntimeFormat
+	value := string(jsonValue)
+
+	// By convention, a JSON null leaves the value unchanged.
+	if value == "null" {
+		return nil
 	}
 
-	// Split the string to isolate the part containing the number.
-	parts := strings.Split(unquotedJSONValue, " ")
+	// If the value isn't a string, it should be a whole number of minutes.
+	if !strings.HasPrefix(value, `"`) {
+		i, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("%w: %s must be %s", ErrInvalidRuntimeFormat, value, acceptedRuntimeFormats)
+		}
 
-	// Sanity check the parts of the string to make sure it was in the expected format.
-	// If it isn't, we return the ErrInvalidRuntimeFormat error again.
-	if len(parts) != 2 || parts[1] != "mins" {
-		return ErrInvalidRuntimeFormat
+		*r = Runtime(i)
+		return nil
+	}
+
+	// Otherwise remove the surrounding double-quotes from the string and parse it.
+	unquotedJSONValue, err := strconv.Unquote(value)
+	if err != nil {
+		return fmt.Errorf("%w: %s must be %s", ErrInvalidRuntimeFormat, value, acceptedRuntimeFormats)
 	}
 
-	// Otherwise, parse the string containing the number into an i32. Again, if this falls return the
-	// ErrInvalidRuntimeFormat error.
-	i, err := strconv.ParseInt(parts[0], 10, 32)
+	runtime, err := ParseRuntime(unquotedJSONValue)
 	if err != nil {
-		return ErrInvalidRuntimeFormat
+		return err
 	}
 
-	// Convert the int32 to a Runtime type and assign this to the receiver. Note that we use the * operator to
-	// deference the receiver (which is a pointer to a Runtime type) in order to set the underlying value of the
-	// pointer.
-	*r = Runtime(i)
+	// Assign the runtime to the receiver. Note that we use the * operator to deference the receiver (which is a
+	// pointer to a Runtime type) in order to set the underlying value of the pointer.
+	*r = runtime
 
 	return nil
 }
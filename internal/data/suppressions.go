@@ -0,0 +1,123 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Suppression is an address on the email suppression list, which emails aren't sent to because it hard-bounced. The
+// reason is the SMTP server's reply when it bounced.
+type Suppression struct {
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	Reason    string    `json:"reason"`
+}
+
+// SuppressionModel wraps the connection pool for the email_suppressions table. It implements the
+// mailer.SuppressionList interface.
+type SuppressionModel struct {
+	DB DBTX
+}
+
+// IsSuppressed reports whether the address is on the suppression list. As the email column is citext, the comparison
+// ignores case.
+func (m SuppressionModel) IsSuppressed(email string) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM email_suppressions WHERE email = $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var suppressed bool
+
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(&suppressed)
+	if err != nil {
+		return false, err
+	}
+
+	return suppressed, nil
+}
+
+// Suppress adds the address to the suppression list. If it is already there, the reason is replaced with the new one,
+// but the time it was first added is kept.
+func (m SuppressionModel) Suppress(email, reason string) error {
+	query := `
+		INSERT INTO email_suppressions (email, reason)
+		VALUES ($1, $2)
+		ON CONFLICT (email) DO UPDATE SET reason = EXCLUDED.reason`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email, reason)
+	return err
+}
+
+// GetAll returns a page of the suppression list. If email isn't empty, only the addresses which contain it are
+// returned, so that a whole domain can be looked up at once.
+func (m SuppressionModel) GetAll(email string, filters Filters) ([]*Suppression, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), email, created_at, reason
+		FROM email_suppressions
+		WHERE (strpos(email, $1::citext) > 0 OR $1 = '')
+		ORDER BY %s %s, email ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, email, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	suppressions := []*Suppression{}
+
+	for rows.Next() {
+		var suppression Suppression
+
+		err := rows.Scan(
+			&totalRecords,
+			&suppression.Email,
+			&suppression.CreatedAt,
+			&suppression.Reason,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		suppressions = append(suppressions, &suppression)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return suppressions, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Delete removes the address from the suppression list, so that emails are sent to it again.
+func (m SuppressionModel) Delete(email string) error {
+	query := `DELETE FROM email_suppressions WHERE email = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, email)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
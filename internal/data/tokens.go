@@ -4,7 +4,6 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
-	"database/sql"
 	"encoding/base32"
 	"greenlight.luismatosgarcia.dev/internal/validator"
 	"time"
@@ -12,7 +11,7 @@ import (
 
 // Define the TokenModel type.
 type TokenModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 // ScopeActivation - Define constants for the token scope. For now, we just define the scope "activation"
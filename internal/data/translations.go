@@ -0,0 +1,298 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"greenlight.luismatosgarcia.dev/internal/validator"
+	"time"
+)
+
+// MovieTitle is an alternative title for a movie in a specific language.
+type MovieTitle struct {
+	Language string `json:"language"`
+	Title    string `json:"title"`
+}
+
+// GenreName is the name of a genre in a specific language. The genre itself is always identified by its canonical
+// code, which is what's stored in the genres array of a movie.
+type GenreName struct {
+	Language string `json:"language"`
+	Name     string `json:"name"`
+}
+
+// TranslationModel wraps the connection pool for the movie_titles and genre_translations tables.
+type TranslationModel struct {
+	DB      DBTX
+	changed movieChangeHook
+}
+
+// ValidateLanguage checks that the language is a well-formed, lowercase language tag.
+func ValidateLanguage(v *validator.Validator, key, language string) {
+	v.Check(language != "", key, "must be provided")
+	v.Check(len(language) <= 35, key, "must not be more than 35 bytes long")
+	v.Check(validator.Matches(language, validator.LanguageTagRX), key, "must be a valid language tag")
+}
+
+func ValidateMovieTitle(v *validator.Validator, title *MovieTitle) {
+	ValidateLanguage(v, "language", title.Language)
+
+	v.Check(title.Title != "", "title", "must be provided")
+	v.Check(len(title.Title) <= 500, "title", "must not be more than 500 bytes long")
+}
+
+func ValidateGenreName(v *validator.Validator, name *GenreName) {
+	ValidateLanguage(v, "language", name.Language)
+
+	v.Check(name.Name != "", "name", "must be provided")
+	v.Check(len(name.Name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+// GetTitlesForMovie returns all the alternative titles for a movie, ordered by language.
+func (m TranslationModel) GetTitlesForMovie(movieID int64) ([]*MovieTitle, error) {
+	query := `
+		SELECT language, title
+		FROM movie_titles
+		WHERE movie_id = $1
+		ORDER BY language`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	titles := []*MovieTitle{}
+
+	for rows.Next() {
+		var title MovieTitle
+
+		err := rows.Scan(&title.Language, &title.Title)
+		if err != nil {
+			return nil, err
+		}
+
+		titles = append(titles, &title)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return titles, nil
+}
+
+// SetTitle adds or replaces the movie's title in the given language.
+func (m TranslationModel) SetTitle(movieID int64, title *MovieTitle) error {
+	defer m.changed.notify()
+
+	query := `
+		INSERT INTO movie_titles (movie_id, language, title)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (movie_id, language) DO UPDATE SET title = EXCLUDED.title`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, movieID, title.Language, title.Title)
+	return err
+}
+
+// DeleteTitle removes the movie's title in the given language, returning an ErrRecordNotFound error if there isn't one.
+func (m TranslationModel) DeleteTitle(movieID int64, language string) error {
+	defer m.changed.notify()
+
+	query := `DELETE FROM movie_titles WHERE movie_id = $1 AND language = $2`
+
+	return m.execExpectingRow(query, movieID, language)
+}
+
+// GetNamesForGenre returns all the translated names for a genre, ordered by language.
+func (m TranslationModel) GetNamesForGenre(genre string) ([]*GenreName, error) {
+	query := `
+		SELECT language, name
+		FROM genre_translations
+		WHERE genre = $1
+		ORDER BY language`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, genre)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []*GenreName{}
+
+	for rows.Next() {
+		var name GenreName
+
+		err := rows.Scan(&name.Language, &name.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, &name)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// SetGenreName adds or replaces the name of a genre in the given language.
+func (m TranslationModel) SetGenreName(genre string, name *GenreName) error {
+	defer m.changed.notify()
+
+	query := `
+		INSERT INTO genre_translations (genre, language, name)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (genre, language) DO UPDATE SET name = EXCLUDED.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, genre, name.Language, name.Name)
+	return err
+}
+
+// DeleteGenreName removes the name of a genre in the given language, returning an ErrRecordNotFound error if there
+// isn't one.
+func (m TranslationModel) DeleteGenreName(genre, language string) error {
+	defer m.changed.notify()
+
+	query := `DELETE FROM genre_translations WHERE genre = $1 AND language = $2`
+
+	return m.execExpectingRow(query, genre, language)
+}
+
+// Localize replaces the title of each movie with its title in the first of the languages that it has one for, and
+// fills in the GenreNames in the same way. The languages should be in order of preference. The canonical title is kept
+// in OriginalTitle whenever it is replaced, and genres without a translation are left out of GenreNames, so clients
+// should fall back to the genre code. Movies without an ID (because it wasn't one of the requested fields) are left
+// as they are.
+func (m TranslationModel) Localize(movies []*Movie, languages []string) error {
+	if len(movies) == 0 || len(languages) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(movies))
+	genres := []string{}
+
+	for _, movie := range movies {
+		if movie.ID > 0 {
+			ids = append(ids, movie.ID)
+		}
+		genres = append(genres, movie.Genres...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// For each movie, pick the title in the most preferred language. DISTINCT ON keeps the first row for each movie,
+	// and array_position() sorts the rows by where their language comes in the list of preferences.
+	titles := make(map[int64]string)
+
+	query := `
+		SELECT DISTINCT ON (movie_id) movie_id, title
+		FROM movie_titles
+		WHERE movie_id = ANY($1) AND language = ANY($2)
+		ORDER BY movie_id, array_position($2, language)`
+
+	err := m.scanPairs(ctx, query, func(rows *sql.Rows) error {
+		var id int64
+		var title string
+
+		err := rows.Scan(&id, &title)
+		titles[id] = title
+		return err
+	}, pq.Array(ids), pq.Array(languages))
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]string)
+
+	query = `
+		SELECT DISTINCT ON (genre) genre, name
+		FROM genre_translations
+		WHERE genre = ANY($1) AND language = ANY($2)
+		ORDER BY genre, array_position($2, language)`
+
+	err = m.scanPairs(ctx, query, func(rows *sql.Rows) error {
+		var genre, name string
+
+		err := rows.Scan(&genre, &name)
+		names[genre] = name
+		return err
+	}, pq.Array(genres), pq.Array(languages))
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		if title, ok := titles[movie.ID]; ok && title != movie.Title {
+			movie.OriginalTitle = movie.Title
+			movie.Title = title
+		}
+
+		for _, genre := range movie.Genres {
+			if name, ok := names[genre]; ok {
+				if movie.GenreNames == nil {
+					movie.GenreNames = make(map[string]string)
+				}
+				movie.GenreNames[genre] = name
+			}
+		}
+	}
+
+	return nil
+}
+
+// The scanPairs() helper runs a query and calls scan for each row in the result.
+func (m TranslationModel) scanPairs(ctx context.Context, query string, scan func(*sql.Rows) error, args ...any) error {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err = scan(rows)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// The execExpectingRow() helper runs a query which should affect one row, returning an ErrRecordNotFound error if it
+// doesn't affect any.
+func (m TranslationModel) execExpectingRow(query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -103,7 +103,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 // UserModel Create a UserModel struct which wraps the connection pool.
 type UserModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 // Insert a new record in the database for the user, Note that the id, created_at and version fields are all
@@ -137,6 +137,43 @@ func (m UserModel) Insert(user *User) error {
 
 }
 
+// Get retrieves the details of a user by their ID, returning an ErrRecordNotFound error if there isn't one.
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE id = $1`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
 // GetByEmail - Retrieve the User details from the database based on the user's email address. Because we have a UNIQUE constraint
 // on the email column, this SQL query will only return one record (or none at all, in which case we return a
 // ErrRecordNotFound error).
@@ -0,0 +1,218 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// WatchlistEntry is a movie on a user's watchlist. Entries are kept in the order chosen by the user, with positions
+// numbered from 1 and no gaps, and each one can be marked as watched.
+type WatchlistEntry struct {
+	MovieID  int64     `json:"movie_id"`
+	Title    string    `json:"title"`
+	Position int32     `json:"position"`
+	Watched  bool      `json:"watched"`
+	AddedAt  time.Time `json:"added_at"`
+}
+
+// WatchlistModel wraps the connection pool for the watchlist_entries table.
+type WatchlistModel struct {
+	DB      DBTX
+	changed movieChangeHook
+}
+
+// GetAllForUser returns a page of the entries on a user's watchlist, in order. If watched is not nil, only the
+// entries with a matching watched flag are returned.
+func (m WatchlistModel) GetAllForUser(userID int64, watched *bool, filters Filters) ([]*WatchlistEntry, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), watchlist_entries.movie_id, movies.title, watchlist_entries.position,
+			watchlist_entries.watched, watchlist_entries.added_at
+		FROM watchlist_entries
+		INNER JOIN movies ON movies.id = watchlist_entries.movie_id
+		WHERE watchlist_entries.user_id = $1
+		AND ($2::boolean IS NULL OR watchlist_entries.watched = $2)
+		ORDER BY watchlist_entries.position
+		LIMIT $3 OFFSET $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, watched, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchlistEntry{}
+
+	for rows.Next() {
+		var entry WatchlistEntry
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.MovieID,
+			&entry.Title,
+			&entry.Position,
+			&entry.Watched,
+			&entry.AddedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return entries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Add puts a movie on a user's watchlist, or changes its entry if it is already there. If position is zero a new
+// entry goes at the end of the list and an existing entry stays where it is, otherwise the entry is moved to that
+// position (or to the end, if the position is past it) and the entries around it are renumbered. If watched is nil
+// a new entry is unwatched and an existing entry keeps its flag. It returns an ErrRecordNotFound error if the movie
+// doesn't exist or has been deleted.
+func (m WatchlistModel) Add(userID, movieID int64, position int32, watched *bool) (*WatchlistEntry, error) {
+	defer m.changed.notify()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	entry := &WatchlistEntry{MovieID: movieID}
+
+	err := withTx(ctx, m.DB, func(tx DBTX) error {
+		// Lock the user's row so that changes to the same watchlist are made one at a time, and the positions can't
+		// get out of step.
+		_, err := tx.ExecContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, `SELECT title FROM movies WHERE id = $1 AND deleted_at IS NULL`, movieID).Scan(&entry.Title)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		var current int32
+		var count int32
+
+		err = tx.QueryRowContext(ctx, `
+			SELECT COALESCE(max(position) FILTER (WHERE movie_id = $2), 0), count(*)
+			FROM watchlist_entries
+			WHERE user_id = $1`, userID, movieID).Scan(&current, &count)
+		if err != nil {
+			return err
+		}
+
+		exists := current > 0
+
+		// Work out where the entry should go. An existing entry doesn't add to the length of the list.
+		last := count + 1
+		if exists {
+			last = count
+		}
+
+		switch {
+		case position == 0 && exists:
+			position = current
+		case position == 0 || position > last:
+			position = last
+		}
+
+		// Close the gap left by the entry's old position, and then open one up at its new position.
+		if exists {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE watchlist_entries SET position = position - 1
+				WHERE user_id = $1 AND movie_id <> $2 AND position > $3`, userID, movieID, current)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE watchlist_entries SET position = position + 1
+			WHERE user_id = $1 AND movie_id <> $2 AND position >= $3`, userID, movieID, position)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			INSERT INTO watchlist_entries (user_id, movie_id, position, watched)
+			VALUES ($1, $2, $3, COALESCE($4, false))
+			ON CONFLICT (user_id, movie_id) DO UPDATE
+			SET position = EXCLUDED.position, watched = COALESCE($4, watchlist_entries.watched)
+			RETURNING position, watched, added_at`
+
+		return tx.QueryRowContext(ctx, query, userID, movieID, position, watched).Scan(
+			&entry.Position,
+			&entry.Watched,
+			&entry.AddedAt,
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// Remove takes a movie off a user's watchlist and renumbers the entries after it. It returns an ErrRecordNotFound
+// error if the movie isn't on the watchlist.
+func (m WatchlistModel) Remove(userID, movieID int64) error {
+	defer m.changed.notify()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx DBTX) error {
+		_, err := tx.ExecContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID)
+		if err != nil {
+			return err
+		}
+
+		var position int32
+
+		query := `DELETE FROM watchlist_entries WHERE user_id = $1 AND movie_id = $2 RETURNING position`
+
+		err = tx.QueryRowContext(ctx, query, userID, movieID).Scan(&position)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE watchlist_entries SET position = position - 1
+			WHERE user_id = $1 AND position > $2`, userID, position)
+		return err
+	})
+}
+
+// removeFromWatchlists takes a movie off every user's watchlist, closing the gap that it leaves in each one. It is
+// called from MovieModel.Delete(), inside the same transaction as the deletion.
+func removeFromWatchlists(ctx context.Context, tx DBTX, movieID int64) error {
+	query := `
+		WITH removed AS (
+			DELETE FROM watchlist_entries WHERE movie_id = $1 RETURNING user_id, position
+		)
+		UPDATE watchlist_entries SET position = watchlist_entries.position - 1
+		FROM removed
+		WHERE watchlist_entries.user_id = removed.user_id AND watchlist_entries.position > removed.position`
+
+	_, err := tx.ExecContext(ctx, query, movieID)
+	return err
+}
@@ -0,0 +1,409 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"greenlight.luismatosgarcia.dev/internal/validator"
+	"net/url"
+	"time"
+)
+
+// WebhookEvents holds the events that webhooks can subscribe to. They match the operations in the movie changes feed.
+var WebhookEvents = []string{"movie.created", "movie.updated", "movie.deleted"}
+
+// The statuses of a webhook delivery. A delivery is pending until it either succeeds or runs out of attempts, at which
+// point it has failed.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a subscription to the catalogue events. Whenever one of its events happens, a JSON payload is POSTed to
+// the URL, signed with the secret. The secret is never included in responses, as it is only needed by the receiver.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Version   int32     `json:"version"`
+}
+
+// WebhookDelivery is an entry in the webhook outbox: one event to be sent to one webhook, along with the outcome of the
+// latest attempt to send it. ResponseStatus is zero if there hasn't been an attempt yet, or if the last attempt didn't
+// get a response at all (in which case LastError says why).
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	WebhookID      int64           `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at,omitempty"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+
+	// URL and Secret are copied from the webhook when a delivery is claimed for sending.
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// WebhookModel wraps the connection pool for the webhooks table.
+type WebhookModel struct {
+	DB DBTX
+}
+
+// WebhookDeliveryModel wraps the connection pool for the webhook_deliveries table.
+type WebhookDeliveryModel struct {
+	DB DBTX
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(len(webhook.URL) <= 2000, "url", "must not be more than 2000 bytes long")
+
+	if u, err := url.Parse(webhook.URL); webhook.URL != "" {
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+	}
+
+	v.Check(len(webhook.Secret) >= 16, "secret", "must be at least 16 bytes long")
+	v.Check(len(webhook.Secret) <= 500, "secret", "must not be more than 500 bytes long")
+
+	v.Check(webhook.Events != nil, "events", "must be provided")
+	v.Check(len(webhook.Events) >= 1, "events", "must contain at least 1 event")
+	v.Check(validator.Unique(webhook.Events), "events", "must not contain duplicate values")
+
+	for _, event := range webhook.Events {
+		v.Check(validator.PermittedValue(event, WebhookEvents...), "events", "must only contain movie.created, movie.updated or movie.deleted")
+	}
+}
+
+// Insert adds a new webhook, reading the system-generated id, created_at and version values back into the struct.
+func (m WebhookModel) Insert(webhook *Webhook) error {
+	query := `
+		INSERT INTO webhooks (url, secret, events, active)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []any{webhook.URL, webhook.Secret, pq.Array(webhook.Events), webhook.Active}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Version)
+}
+
+// Get returns a specific webhook, or an ErrRecordNotFound error if there isn't one with the provided ID.
+func (m WebhookModel) Get(id int64) (*Webhook, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, url, secret, events, active, version
+		FROM webhooks
+		WHERE id = $1`
+
+	var webhook Webhook
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.URL,
+		&webhook.Secret,
+		pq.Array(&webhook.Events),
+		&webhook.Active,
+		&webhook.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &webhook, nil
+}
+
+// GetAll returns every webhook, oldest first. There are only ever a handful of them, so they aren't paginated.
+func (m WebhookModel) GetAll() ([]*Webhook, error) {
+	query := `
+		SELECT id, created_at, url, secret, events, active, version
+		FROM webhooks
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		var webhook Webhook
+
+		err := rows.Scan(
+			&webhook.ID,
+			&webhook.CreatedAt,
+			&webhook.URL,
+			&webhook.Secret,
+			pq.Array(&webhook.Events),
+			&webhook.Active,
+			&webhook.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// Update saves the changes to a webhook, using the version number to check for edit conflicts. Deliveries which are
+// already in the outbox are sent to the new URL with the new secret, but only events which happen after the update are
+// filtered by the new list of events.
+func (m WebhookModel) Update(webhook *Webhook) error {
+	query := `
+		UPDATE webhooks SET url = $1, secret = $2, events = $3, active = $4, version = version + 1
+		WHERE id = $5 AND version = $6
+		RETURNING version`
+
+	args := []any{webhook.URL, webhook.Secret, pq.Array(webhook.Events), webhook.Active, webhook.ID, webhook.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a webhook, along with its deliveries (including any which haven't been sent yet).
+func (m WebhookModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM webhooks WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAllForWebhook returns a page of the deliveries to a webhook, which is the webhook's delivery log. If status isn't
+// empty, only the deliveries with that status are returned.
+func (m WebhookDeliveryModel) GetAllForWebhook(webhookID int64, status string, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, webhook_id, event, payload, status, attempts,
+			CASE WHEN status = 'pending' THEN next_attempt_at END, last_attempt_at, COALESCE(response_status, 0),
+			last_error
+		FROM webhook_deliveries
+		WHERE webhook_id = $1 AND (status = $2 OR $2 = '')
+		ORDER BY %s %s, id DESC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+
+		err := rows.Scan(
+			&totalRecords,
+			&delivery.ID,
+			&delivery.CreatedAt,
+			&delivery.WebhookID,
+			&delivery.Event,
+			&delivery.Payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.NextAttemptAt,
+			&delivery.LastAttemptAt,
+			&delivery.ResponseStatus,
+			&delivery.LastError,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return deliveries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Claim picks up to limit pending deliveries which are due to be sent, and pushes their next attempt back by the lease
+// duration. This stops any other worker from claiming them while they are being sent, and if this worker dies before
+// recording the outcome, they are simply retried once the lease runs out. The deliveries are returned with the URL and
+// secret of their webhook, oldest first.
+//
+// Deliveries to webhooks which have been deactivated are left in the outbox, and are sent if the webhook is activated
+// again.
+func (m WebhookDeliveryModel) Claim(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	query := `
+		WITH claimed AS (
+			UPDATE webhook_deliveries SET next_attempt_at = now() + make_interval(secs => $2)
+			WHERE id IN (
+				SELECT webhook_deliveries.id
+				FROM webhook_deliveries
+				INNER JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id
+				WHERE webhook_deliveries.status = 'pending' AND webhook_deliveries.next_attempt_at <= now()
+					AND webhooks.active
+				ORDER BY webhook_deliveries.next_attempt_at, webhook_deliveries.id
+				LIMIT $1
+				FOR UPDATE OF webhook_deliveries SKIP LOCKED
+			)
+			RETURNING id, created_at, webhook_id, event, payload, attempts
+		)
+		SELECT claimed.id, claimed.created_at, claimed.webhook_id, claimed.event, claimed.payload, claimed.attempts,
+			webhooks.url, webhooks.secret
+		FROM claimed
+		INNER JOIN webhooks ON webhooks.id = claimed.webhook_id
+		ORDER BY claimed.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		delivery := WebhookDelivery{Status: DeliveryPending}
+
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.CreatedAt,
+			&delivery.WebhookID,
+			&delivery.Event,
+			&delivery.Payload,
+			&delivery.Attempts,
+			&delivery.URL,
+			&delivery.Secret,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// RecordAttempt saves the outcome of an attempt to send a delivery, which the caller has filled in on the struct:
+// the Status, the ResponseStatus (zero if there was no response) and the LastError. For a delivery which is still
+// pending, NextAttemptAt must be set to when it should be retried.
+func (m WebhookDeliveryModel) RecordAttempt(delivery *WebhookDelivery) error {
+	if delivery.Status == DeliveryPending && delivery.NextAttemptAt == nil {
+		return errors.New("pending webhook delivery must have a next attempt time")
+	}
+
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = attempts + 1, last_attempt_at = now(), response_status = $2, last_error = $3,
+			next_attempt_at = COALESCE($4, next_attempt_at)
+		WHERE id = $5
+		RETURNING attempts, last_attempt_at`
+
+	args := []any{delivery.Status, nullInt32(int32(delivery.ResponseStatus)), delivery.LastError, delivery.NextAttemptAt, delivery.ID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&delivery.Attempts, &delivery.LastAttemptAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteOlderThan removes the deliveries which succeeded or failed more than the retention period ago, and returns
+// the number of deliveries that were removed. Pending deliveries are always kept.
+func (m WebhookDeliveryModel) DeleteOlderThan(retention time.Duration) (int64, error) {
+	query := `DELETE FROM webhook_deliveries WHERE status <> 'pending' AND last_attempt_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
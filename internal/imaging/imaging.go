@@ -0,0 +1,83 @@
+package imaging
+
+import (
+	"image"
+	"image/draw"
+)
+
+// ToRGBA returns the image as an *image.RGBA, converting it if necessary. Resizing works directly on the pixel data,
+// so converting once up front saves doing it for every thumbnail.
+func ToRGBA(src image.Image) *image.RGBA {
+	if rgba, ok := src.(*image.RGBA); ok {
+		return rgba
+	}
+
+	b := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+
+	return rgba
+}
+
+// Fit scales the image down so that it is no wider than width, keeping its aspect ratio. Images which are already
+// narrow enough are returned unchanged, as we never scale up.
+//
+// Each pixel in the result is the average of the block of source pixels that it covers (a box filter). This is much
+// better than just picking the nearest pixel when shrinking an image by a large amount, and only needs the standard
+// library.
+func Fit(src *image.RGBA, width int) *image.RGBA {
+	b := src.Bounds()
+	if b.Dx() <= width {
+		return src
+	}
+
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0, y1 := span(y, height, b.Min.Y, b.Dy())
+
+		for x := 0; x < width; x++ {
+			x0, x1 := span(x, width, b.Min.X, b.Dx())
+
+			var r, g, bl, a, n uint64
+
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[src.PixOffset(x0, sy):src.PixOffset(x1, sy)]
+
+				for i := 0; i < len(row); i += 4 {
+					r += uint64(row[i])
+					g += uint64(row[i+1])
+					bl += uint64(row[i+2])
+					a += uint64(row[i+3])
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(bl / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// The span() helper returns the range of source pixels covered by destination pixel i, when srcSize pixels starting
+// at min are shrunk down to dstSize pixels. The range is never empty.
+func span(i, dstSize, min, srcSize int) (int, int) {
+	start := min + i*srcSize/dstSize
+	end := min + (i+1)*srcSize/dstSize
+
+	if end <= start {
+		end = start + 1
+	}
+
+	return start, end
+}
@@ -0,0 +1,224 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight.luismatosgarcia.dev/internal/data"
+	"greenlight.luismatosgarcia.dev/internal/jsonlog"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// permanentError wraps an error which retrying won't fix.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error returned by a handler as one that retrying won't fix (like an email address which doesn't
+// exist), so that the job is dead straight away instead of being retried.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether an error was marked with Permanent().
+func IsPermanent(err error) bool {
+	var permanent permanentError
+	return errors.As(err, &permanent)
+}
+
+// A failed job is first retried after retryBase, and then after twice as long each time, up to retryCeiling.
+const (
+	retryBase    = 10 * time.Second
+	retryCeiling = time.Hour
+)
+
+// A handler runs a job with its JSON-encoded payload.
+type handler func(ctx context.Context, payload json.RawMessage) error
+
+// Runner runs the jobs in the queue with a pool of workers. Each worker claims one job at a time, runs it with the
+// handler registered for its kind, and then records the outcome: failed jobs are retried with exponential backoff until
+// they run out of attempts, after which they are dead.
+//
+// Every worker polls the queue when it is idle, but Wake() can be called after enqueuing a job so that it is picked up
+// straight away.
+type Runner struct {
+	jobs     data.JobModel
+	logger   *jsonlog.Logger
+	workers  int
+	timeout  time.Duration
+	handlers map[string]handler
+	wake     chan struct{}
+}
+
+// NewRunner returns a Runner which claims jobs through the given model, with the given number of workers. Each job is
+// given up to timeout to finish, after which its context is cancelled.
+func NewRunner(jobs data.JobModel, logger *jsonlog.Logger, workers int, timeout time.Duration) *Runner {
+	return &Runner{
+		jobs:     jobs,
+		logger:   logger,
+		workers:  workers,
+		timeout:  timeout,
+		handlers: make(map[string]handler),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Register sets fn as the handler for jobs of the given kind. The payload of each job is decoded into a T before fn is
+// called, and a payload which can't be decoded is a permanent error. Handlers must be registered before Run() is
+// called, and only jobs of the registered kinds are claimed.
+func Register[T any](r *Runner, kind string, fn func(ctx context.Context, payload T) error) {
+	r.handlers[kind] = func(ctx context.Context, js json.RawMessage) error {
+		var payload T
+
+		err := json.Unmarshal(js, &payload)
+		if err != nil {
+			return Permanent(fmt.Errorf("decoding payload: %w", err))
+		}
+
+		return fn(ctx, payload)
+	}
+}
+
+// Wake tells an idle worker to check the queue straight away, rather than at its next poll.
+func (r *Runner) Wake() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run starts the workers, and blocks until the done channel has been closed and every worker has finished the job
+// it was running. Workers stop claiming new jobs as soon as done is closed, but a job which has already started is
+// allowed to finish (or time out), so that its outcome is recorded.
+func (r *Runner) Run(done <-chan struct{}) {
+	kinds := make([]string, 0, len(r.handlers))
+	for kind := range r.handlers {
+		kinds = append(kinds, kind)
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < r.workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			r.work(done, kinds)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// The work() method is the loop that each worker runs.
+func (r *Runner) work(done <-chan struct{}, kinds []string) {
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		// The lease is longer than the job's timeout, so that it doesn't run out while the job is still running.
+		job, err := r.jobs.Claim(kinds, r.timeout+time.Minute)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				r.logger.PrintError(err, nil)
+			}
+
+			// Wait for a job to be enqueued, or poll again in a second.
+			select {
+			case <-done:
+				return
+			case <-r.wake:
+			case <-time.After(time.Second):
+			}
+
+			continue
+		}
+
+		r.run(job)
+	}
+}
+
+// The run() method runs a claimed job and records the outcome.
+func (r *Runner) run(job *data.Job) {
+	properties := map[string]string{
+		"job_id":   strconv.FormatInt(job.ID, 10),
+		"kind":     job.Kind,
+		"attempts": strconv.Itoa(job.Attempts),
+	}
+
+	var err error
+
+	// A job can only have been attempted more than its maximum if a worker claimed it and then died without recording
+	// the outcome (maybe because the job itself crashed the process), so don't run it again.
+	if job.Attempts > job.MaxAttempts {
+		err = Permanent(errors.New("worker was lost while running the job"))
+	} else {
+		err = r.call(job)
+	}
+
+	switch {
+	case err == nil:
+		err = r.jobs.Complete(job)
+
+	case IsPermanent(err) || job.Attempts >= job.MaxAttempts:
+		r.logger.PrintError(fmt.Errorf("job is dead: %w", err), properties)
+		err = r.jobs.Bury(job, err.Error())
+
+	default:
+		r.logger.PrintError(fmt.Errorf("job failed: %w", err), properties)
+		err = r.jobs.Retry(job, time.Now().Add(Backoff(job.Attempts, retryBase, retryCeiling)), err.Error())
+	}
+
+	// If the job's lease ran out then another worker has claimed it, and will record its own outcome.
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		r.logger.PrintError(err, properties)
+	}
+}
+
+// The call() method calls the handler for a job, turning a panic into an error so that one bad job can't take down
+// the worker.
+func (r *Runner) call(job *data.Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	return r.handlers[job.Kind](ctx, job.Payload)
+}
+
+// Backoff returns how long to wait before retrying something which has failed the given number of times. The wait
+// starts at base and doubles with every failure, up to ceiling, and up to a fifth is added at random so that retries of
+// things which failed together (say, jobs that ran while the mail server was down) aren't all made at the same moment.
+// A number of attempts below 1 is treated as 1.
+func Backoff(attempts int, base, ceiling time.Duration) time.Duration {
+	delay := base
+
+	for i := 1; i < attempts && delay < ceiling; i++ {
+		delay *= 2
+	}
+
+	if delay > ceiling {
+		delay = ceiling
+	}
+
+	return delay + time.Duration(rand.Int63n(int64(delay/5)+1))
+}
@@ -0,0 +1,32 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		min      time.Duration
+		max      time.Duration
+	}{
+		{-5, 10 * time.Second, 12 * time.Second},
+		{0, 10 * time.Second, 12 * time.Second},
+		{1, 10 * time.Second, 12 * time.Second},
+		{2, 20 * time.Second, 24 * time.Second},
+		{4, 80 * time.Second, 96 * time.Second},
+		{9, 2560 * time.Second, 3072 * time.Second},
+		{10, time.Hour, 72 * time.Minute},
+		{1000, time.Hour, 72 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			got := Backoff(tt.attempts, 10*time.Second, time.Hour)
+			if got < tt.min || got > tt.max {
+				t.Fatalf("Backoff(%d) = %s; want between %s and %s", tt.attempts, got, tt.min, tt.max)
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-mail/mail/v2"
+	"net/textproto"
+	"regexp"
+)
+
+// ErrSuppressed is returned when an email isn't sent because the recipient is on the suppression list.
+var ErrSuppressed = errors.New("recipient is on the email suppression list")
+
+// enhancedCodeRX matches the enhanced status code (RFC 3463) which most servers put at the start of their reply, such
+// as the "5.1.1" in "550 5.1.1 User unknown".
+var enhancedCodeRX = regexp.MustCompile(`^([245])\.(\d{1,3})\.(\d{1,3})\b`)
+
+// SMTPError is returned by the SMTP transport when the server replies with an error, so that the caller can tell a
+// temporary failure (a 4xx reply, such as a full mailbox or greylisting) which is worth trying again, from a permanent
+// one (a 5xx reply) which isn't.
+type SMTPError struct {
+	Code         int
+	EnhancedCode string
+	Message      string
+}
+
+func (e *SMTPError) Error() string {
+	return fmt.Sprintf("smtp: %d %s", e.Code, e.Message)
+}
+
+// Temporary reports whether the server said that sending the email might work if it is tried again later.
+func (e *SMTPError) Temporary() bool {
+	return e.Code < 500
+}
+
+// HardBounce reports whether the server said that the recipient's address doesn't exist or can't receive email, as
+// opposed to rejecting this particular email (for looking like spam, for example). When the server gives an enhanced
+// status code we go by that: 5.1.x is a bad address and 5.2.1 a disabled mailbox. Otherwise we fall back to the basic
+// codes for an unknown or invalid mailbox.
+func (e *SMTPError) HardBounce() bool {
+	if e.Temporary() {
+		return false
+	}
+
+	if match := enhancedCodeRX.FindStringSubmatch(e.EnhancedCode); match != nil {
+		return match[2] == "1" || (match[2] == "2" && match[3] == "1")
+	}
+
+	switch e.Code {
+	case 550, 551, 553:
+		return true
+	default:
+		return false
+	}
+}
+
+// The smtpError() function converts an error reply from the SMTP server into an SMTPError. Any other error, such as a
+// timeout or a refused connection, is returned as it is, and is treated as temporary.
+func smtpError(err error) error {
+	// The mail package wraps the errors from sending in a SendError, which doesn't support errors.As(), so we unwrap
+	// it by hand.
+	var sendErr *mail.SendError
+	if errors.As(err, &sendErr) {
+		err = sendErr.Cause
+	}
+
+	var replyErr *textproto.Error
+	if !errors.As(err, &replyErr) {
+		return err
+	}
+
+	smtpErr := &SMTPError{
+		Code:    replyErr.Code,
+		Message: replyErr.Msg,
+	}
+
+	if match := enhancedCodeRX.FindString(replyErr.Msg); match != "" {
+		smtpErr.EnhancedCode = match
+	}
+
+	return smtpErr
+}
+
+// IsPermanent reports whether an error from sending an email means that trying again won't help: the recipient is
+// suppressed, the template doesn't exist, or the SMTP server gave a permanent (5xx) reply.
+func IsPermanent(err error) bool {
+	if errors.Is(err, ErrSuppressed) || errors.Is(err, ErrTemplateNotFound) {
+		return true
+	}
+
+	var smtpErr *SMTPError
+	if errors.As(err, &smtpErr) {
+		return !smtpErr.Temporary()
+	}
+
+	return false
+}
+
+// IsHardBounce reports whether an error from sending an email means that the recipient's address is bad, and that it
+// should be added to the suppression list.
+func IsHardBounce(err error) bool {
+	var smtpErr *SMTPError
+	return errors.As(err, &smtpErr) && smtpErr.HardBounce()
+}
@@ -3,88 +3,239 @@ package mailer
 import (
 	"bytes"
 	"embed"
-	"github.com/go-mail/mail/v2"
+	"errors"
+	"greenlight.luismatosgarcia.dev/internal/validator"
 	"html/template"
-	"time"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
 )
 
 // Bellow we declare a new variable with the type embed.FS (embedded file system) to hold our email templates.
 // This has a comment directive in the format `//go:embed <path>` IMMEDIATELY ABOVE it, which indicates to GO
 // that we want to store the contents of the ./templates directory in the templateFS embedded file system variable.
+//
+// The templates directory holds the layouts (in layouts/), the partials (in partials/) and the email templates
+// themselves, all in English. A directory named after a language, such as pt/, holds the translated variants, laid
+// out the same way. A language only needs to translate the files that differ, as anything missing falls back to the
+// English version.
 
 //go:embed "templates"
 var templateFS embed.FS
 
-// Define a Mailer struct which contains a mail.Dialer instance (used to connect to a SMTP server) and the sender
-// information for your emails (the name and address you want the email to be from, such as
-// "Alice Smith <alice@example.com>").
-type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+// defaultLanguage is the language of the templates at the top of the templates directory.
+const defaultLanguage = "en"
+
+// ErrTemplateNotFound is returned when there isn't an email template with the given name.
+var ErrTemplateNotFound = errors.New("email template not found")
+
+// Mailer is the interface for sending the application's emails. Send() renders the named template with the dynamic
+// data and sends the result to the recipient, and Render() just renders it. Both take the recipient's preferred
+// languages, most preferred first, and use the first one that the template has been translated into.
+type Mailer interface {
+	Send(recipient, templateFile string, data any, languages ...string) error
+	Render(templateFile string, data any, languages ...string) (*Message, error)
+}
+
+// Message is a rendered email, ready to be handed to a Transport.
+type Message struct {
+	From      string
+	To        string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
 }
 
-func New(host string, port int, username, password, sender string) Mailer {
-	// Initialize a new mail.Dialer instance with the given SMTP server settings. We also configure this to use a
-	// 5-second timeout whenever we send an email.
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 5 * time.Second
+// Transport is the interface for the ways of getting a rendered email to its recipient: SMTP, an mbox file for
+// development, an in-memory Recorder for tests, or Noop to throw them away.
+type Transport interface {
+	Deliver(msg *Message) error
+}
+
+// templateMailer is the Mailer which renders emails from the embedded templates, and delivers them with a Transport.
+// Each template is parsed (along with the layouts and partials) the first time it is used in each language, and then
+// kept in the cache.
+type templateMailer struct {
+	transport Transport
+	sender    string
+
+	mu    sync.RWMutex
+	cache map[string]*template.Template
+}
 
-	// Return a Mailer instance containing the dialer and sender information
-	return Mailer{
-		dialer: dialer,
-		sender: sender,
+// New returns a Mailer which renders emails from the embedded templates and delivers them with the transport. The
+// sender is the name and address you want the emails to be from, such as "Alice Smith <alice@example.com>".
+func New(transport Transport, sender string) Mailer {
+	return &templateMailer{
+		transport: transport,
+		sender:    sender,
+		cache:     make(map[string]*template.Template),
 	}
 }
 
-// Send Define a Send() method on the Mailer type. This takes the recipient email address as the first parameter,
-// the name of the file containing the templates, and any dynamic data for the templates as an any parameter.
-func (m Mailer) Send(recipient, templateFile string, data any) error {
-	// Use the ParseFS() method to parse the required template file from the embedded file system.
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+// Send renders the email and delivers it to the recipient.
+func (m *templateMailer) Send(recipient, templateFile string, data any, languages ...string) error {
+	msg, err := m.Render(templateFile, data, languages...)
 	if err != nil {
 		return err
 	}
 
+	msg.To = recipient
+
+	return m.transport.Deliver(msg)
+}
+
+// Render executes the "subject" template and the "plainBody" and "htmlBody" templates from the layout, and returns the
+// results as a Message with no recipient. Execution is strict: a key which is missing from the data is an error,
+// rather than being rendered as "<no value>".
+func (m *templateMailer) Render(templateFile string, data any, languages ...string) (*Message, error) {
+	tmpl, err := m.template(templateFile, languages)
+	if err != nil {
+		return nil, err
+	}
+
 	// Execute the named template "subject", passing in the dynamic data and storing the result in a bytes.Buffer
 	// variable.
 	subject := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Follow the same pattern to execute the "plainBody" template and store the result in the plainBody variable.
 	plainBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// And likewise with the "htmlBody" template.
 	htmlBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	return &Message{
+		From:      m.sender,
+		Subject:   strings.TrimSpace(subject.String()),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}, nil
+}
+
+// The template() method returns the parsed template for an email in the first of the languages that it has been
+// translated into, from the cache if it has already been parsed.
+func (m *templateMailer) template(templateFile string, languages []string) (*template.Template, error) {
+	dirs := overlayDirs(languages)
+
+	key := strings.Join(dirs, ",") + ":" + templateFile
+
+	m.mu.RLock()
+	tmpl, ok := m.cache[key]
+	m.mu.RUnlock()
+
+	if ok {
+		return tmpl, nil
 	}
 
-	// Use the mail.NewMessage() function to initialize a new mail.Message instance. Then we use the SetHeader()
-	// method to set the email recipient, sender and subject headers, the SetBody() method to set the plain-text body,
-	// and the AddAlternative(0 method to set the HTML body. It's important to note that AddAlternative() should always
-	// be called *after* SetBody().
-	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-
-	// Call the DialAndSend() method on the dialer, passing in the message to send. This opens a connection to the
-	// SMTP server, sends the message, then closes the connection. If there is a timeout, it will return a
-	// "dial tcp: i/o timeout" error.
-	err = m.dialer.DialAndSend(msg)
+	tmpl, err := parseTemplate(templateFile, dirs)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.cache[key] = tmpl
+	m.mu.Unlock()
+
+	return tmpl, nil
+}
+
+// The overlayDirs() function returns the template directories to use for the first of the languages which has
+// translations (or is English), starting with the English templates. A regional language such as "pt-br" uses the
+// templates for its base language "pt" (if there are any) underneath its own, so that it only needs to translate what
+// is different.
+func overlayDirs(languages []string) []string {
+	dirs := []string{"templates"}
+
+	for _, language := range languages {
+		language = strings.ToLower(language)
+
+		base, _, _ := strings.Cut(language, "-")
+		if base == defaultLanguage {
+			break
+		}
+
+		if languageDirExists(base) {
+			dirs = append(dirs, path.Join("templates", base))
+		}
+
+		if language != base && languageDirExists(language) {
+			dirs = append(dirs, path.Join("templates", language))
+		}
+
+		if len(dirs) > 1 {
+			break
+		}
+	}
+
+	return dirs
+}
+
+// The languageDirExists() function reports whether there is a directory of templates for a language. Only language
+// tags are looked up, so that a language can't be used to reach another directory.
+func languageDirExists(language string) bool {
+	if !validator.Matches(language, validator.LanguageTagRX) {
+		return false
+	}
+
+	info, err := fs.Stat(templateFS, path.Join("templates", language))
+
+	return err == nil && info.IsDir()
+}
+
+// The parseTemplate() function parses the layouts and partials from each directory in turn, so that a translated
+// layout or partial replaces the English one, and then the most specific version of the email template itself. The
+// template is set to fail if the data is missing a key.
+func parseTemplate(templateFile string, dirs []string) (*template.Template, error) {
+	if !fs.ValidPath(templateFile) || strings.Contains(templateFile, "/") {
+		return nil, ErrTemplateNotFound
+	}
+
+	emailFile := ""
+
+	for _, dir := range dirs {
+		candidate := path.Join(dir, templateFile)
+
+		if info, err := fs.Stat(templateFS, candidate); err == nil && !info.IsDir() {
+			emailFile = candidate
+		}
+	}
+
+	if emailFile == "" {
+		return nil, ErrTemplateNotFound
+	}
+
+	tmpl := template.New("email").Option("missingkey=error")
+
+	for _, dir := range dirs {
+		for _, pattern := range []string{"layouts/*.gohtml", "partials/*.gohtml"} {
+			matches, err := fs.Glob(templateFS, path.Join(dir, pattern))
+			if err != nil {
+				return nil, err
+			}
+
+			if len(matches) == 0 {
+				continue
+			}
+
+			tmpl, err = tmpl.ParseFS(templateFS, matches...)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	return nil
+	return tmpl.ParseFS(templateFS, emailFile)
 }
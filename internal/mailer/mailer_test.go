@@ -0,0 +1,115 @@
+package mailer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// welcomeData returns the data which the user_welcome.gohtml template expects.
+func welcomeData() map[string]any {
+	return map[string]any{
+		"activationToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+		"userId":          int64(42),
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name      string
+		languages []string
+		subject   string
+	}{
+		{"default", nil, "Welcome to Greenlight!"},
+		{"english", []string{"en-gb"}, "Welcome to Greenlight!"},
+		{"translated", []string{"pt"}, "Bem-vindo ao Greenlight!"},
+		{"regional", []string{"pt-br"}, "Bem-vindo ao Greenlight!"},
+		{"first translated", []string{"xx", "pt"}, "Bem-vindo ao Greenlight!"},
+		{"untranslated", []string{"fr"}, "Welcome to Greenlight!"},
+	}
+
+	m := New(NewRecorder(), "Greenlight <no-reply@greenlight.test>")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := m.Render("user_welcome.gohtml", welcomeData(), tt.languages...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if msg.Subject != tt.subject {
+				t.Errorf("got subject %q; want %q", msg.Subject, tt.subject)
+			}
+
+			for _, body := range []string{msg.PlainBody, msg.HTMLBody} {
+				if !strings.Contains(body, "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU") || !strings.Contains(body, "42") {
+					t.Errorf("body is missing the token or user ID:\n%s", body)
+				}
+			}
+		})
+	}
+}
+
+// A key which is missing from the data must make rendering fail, rather than the email going out with a blank where
+// the value should be.
+func TestRenderMissingKey(t *testing.T) {
+	for _, key := range []string{"activationToken", "userId"} {
+		t.Run(key, func(t *testing.T) {
+			data := welcomeData()
+			delete(data, key)
+
+			recorder := NewRecorder()
+			m := New(recorder, "Greenlight <no-reply@greenlight.test>")
+
+			_, err := m.Render("user_welcome.gohtml", data)
+			if err == nil || !strings.Contains(err.Error(), key) {
+				t.Errorf("got error %v; want one about the missing %q key", err, key)
+			}
+
+			err = m.Send("alice@example.com", "user_welcome.gohtml", data)
+			if err == nil {
+				t.Error("got no error from Send")
+			}
+
+			if n := len(recorder.Messages()); n != 0 {
+				t.Errorf("got %d emails delivered; want 0", n)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateNotFound(t *testing.T) {
+	m := New(NewRecorder(), "Greenlight <no-reply@greenlight.test>")
+
+	for _, name := range []string{"missing.gohtml", "layouts/base.gohtml", "../mailer.go", "pt/user_welcome.gohtml"} {
+		_, err := m.Render(name, welcomeData())
+		if !errors.Is(err, ErrTemplateNotFound) {
+			t.Errorf("%s: got error %v; want ErrTemplateNotFound", name, err)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	recorder := NewRecorder()
+	m := New(recorder, "Greenlight <no-reply@greenlight.test>")
+
+	err := m.Send("alice@example.com", "user_welcome.gohtml", welcomeData(), "pt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := recorder.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d emails; want 1", len(messages))
+	}
+
+	msg := messages[0]
+
+	if msg.To != "alice@example.com" || msg.From != "Greenlight <no-reply@greenlight.test>" {
+		t.Errorf("got email from %q to %q", msg.From, msg.To)
+	}
+
+	if msg.Subject != "Bem-vindo ao Greenlight!" {
+		t.Errorf("got subject %q", msg.Subject)
+	}
+}
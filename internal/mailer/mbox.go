@@ -0,0 +1,82 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Mbox is the Transport which appends emails to a file in mbox format, instead of sending them. It is meant for
+// development: the file can be opened with most mail clients (or just read), so that the emails can be checked
+// without an SMTP server or a network connection.
+type Mbox struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewMbox returns an Mbox transport which writes to the file at path. The file and its directory are created when the
+// first email is written, if they don't exist.
+func NewMbox(path string) *Mbox {
+	return &Mbox{path: path}
+}
+
+// Deliver appends the message to the file. Each message starts with a "From " separator line, and any line in the
+// message which itself starts with "From " is escaped with a ">" (the mboxrd convention), so that it can't be taken
+// for the start of the next message.
+func (t *Mbox) Deliver(msg *Message) error {
+	var raw bytes.Buffer
+
+	_, err := msg.mime().WriteTo(&raw)
+	if err != nil {
+		return err
+	}
+
+	var entry bytes.Buffer
+
+	entry.WriteString("From MAILER-DAEMON " + time.Now().UTC().Format(time.ANSIC) + "\n")
+
+	scanner := bufio.NewScanner(bytes.NewReader(raw.Bytes()))
+	scanner.Buffer(nil, raw.Len()+1)
+
+	for scanner.Scan() {
+		line := bytes.TrimSuffix(scanner.Bytes(), []byte("\r"))
+
+		if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			entry.WriteByte('>')
+		}
+
+		entry.Write(line)
+		entry.WriteByte('\n')
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	entry.WriteByte('\n')
+
+	// Hold the lock while writing, so that two emails sent at the same time can't be interleaved.
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	err = os.MkdirAll(filepath.Dir(t.path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(t.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(entry.Bytes())
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
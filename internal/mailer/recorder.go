@@ -0,0 +1,50 @@
+package mailer
+
+import "sync"
+
+// Recorder is the Transport which keeps emails in memory instead of sending them, so that tests can check which
+// emails were sent and what they said. It is safe for concurrent use.
+type Recorder struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+// NewRecorder returns a Recorder with no messages.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Deliver records the message.
+func (t *Recorder) Deliver(msg *Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	copied := *msg
+	t.messages = append(t.messages, &copied)
+
+	return nil
+}
+
+// Messages returns the messages recorded so far, oldest first.
+func (t *Recorder) Messages() []*Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]*Message(nil), t.messages...)
+}
+
+// Reset forgets every recorded message.
+func (t *Recorder) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = nil
+}
+
+// Noop is the Transport which throws emails away, for when they aren't wanted at all.
+type Noop struct{}
+
+// Deliver does nothing.
+func (Noop) Deliver(msg *Message) error {
+	return nil
+}
@@ -0,0 +1,42 @@
+package mailer
+
+import (
+	"github.com/go-mail/mail/v2"
+	"time"
+)
+
+// SMTP is the Transport which sends emails through an SMTP server.
+type SMTP struct {
+	dialer *mail.Dialer
+}
+
+// NewSMTP returns an SMTP transport for the given server settings.
+func NewSMTP(host string, port int, username, password string) *SMTP {
+	// Initialize a new mail.Dialer instance with the given SMTP server settings. We also configure this to use a
+	// 5-second timeout whenever we send an email.
+	dialer := mail.NewDialer(host, port, username, password)
+	dialer.Timeout = 5 * time.Second
+
+	return &SMTP{dialer: dialer}
+}
+
+// Deliver sends the message. This opens a connection to the SMTP server, sends the message, then closes the
+// connection. If there is a timeout, it will return a "dial tcp: i/o timeout" error. If the server replies with an
+// error, it is returned as an SMTPError, which says whether the failure is temporary.
+func (t *SMTP) Deliver(msg *Message) error {
+	return smtpError(t.dialer.DialAndSend(msg.mime()))
+}
+
+// The mime() method converts the message into a mail.Message. We use the SetHeader() method to set the email
+// recipient, sender and subject headers, the SetBody() method to set the plain-text body, and the AddAlternative()
+// method to set the HTML body. It's important to note that AddAlternative() should always be called *after* SetBody().
+func (msg *Message) mime() *mail.Message {
+	m := mail.NewMessage()
+	m.SetHeader("To", msg.To)
+	m.SetHeader("From", msg.From)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.PlainBody)
+	m.AddAlternative("text/html", msg.HTMLBody)
+
+	return m
+}
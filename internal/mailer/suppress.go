@@ -0,0 +1,62 @@
+package mailer
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+)
+
+// SuppressionList is the interface for the list of addresses that emails must not be sent to, because they
+// hard-bounced. It is implemented by data.SuppressionModel.
+type SuppressionList interface {
+	IsSuppressed(email string) (bool, error)
+	Suppress(email, reason string) error
+}
+
+// suppressingMailer is the Mailer which checks the suppression list before every email, and adds the recipient to it
+// when sending hard-bounces.
+type suppressingMailer struct {
+	Mailer
+	list SuppressionList
+}
+
+// WithSuppressionList returns a Mailer which sends emails with m, except to the addresses on the suppression list,
+// for which Send() returns ErrSuppressed. When the SMTP server says that a recipient's address is bad, the address is
+// added to the list, so that it isn't tried again.
+func WithSuppressionList(m Mailer, list SuppressionList) Mailer {
+	return &suppressingMailer{Mailer: m, list: list}
+}
+
+// Send sends the email unless the recipient is suppressed.
+func (m *suppressingMailer) Send(recipient, templateFile string, data any, languages ...string) error {
+	email := address(recipient)
+
+	suppressed, err := m.list.IsSuppressed(email)
+	if err != nil {
+		return err
+	}
+
+	if suppressed {
+		return ErrSuppressed
+	}
+
+	err = m.Mailer.Send(recipient, templateFile, data, languages...)
+	if IsHardBounce(err) {
+		if suppressErr := m.list.Suppress(email, err.Error()); suppressErr != nil {
+			return errors.Join(err, suppressErr)
+		}
+	}
+
+	return err
+}
+
+// The address() function returns the bare email address from a recipient, which may include a name, as in
+// "Alice Smith <alice@example.com>".
+func address(recipient string) string {
+	addr, err := mail.ParseAddress(recipient)
+	if err != nil {
+		return strings.TrimSpace(recipient)
+	}
+
+	return addr.Address
+}
@@ -0,0 +1,375 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Error is returned when a patch can't be applied to a document, for example because an operation refers to a path
+// which doesn't exist or a test operation fails. The message explains which operation failed and why, so it is safe
+// to send back to the client as-is.
+type Error struct {
+	message string
+}
+
+func (e *Error) Error() string {
+	return e.message
+}
+
+// Operation holds a single JSON Patch (RFC 6902) operation. The Value is kept as a json.RawMessage so that we can tell
+// the difference between a missing value and an explicit null.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Merge applies a JSON Merge Patch (RFC 7396) to a JSON document and returns the patched document. Members of the
+// patch which are null are removed from the document, objects are merged recursively, and any other value
+// (including arrays) replaces the existing one.
+func Merge(doc, patch []byte) ([]byte, error) {
+	var target, p any
+
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, &Error{message: "merge patch must be valid JSON"}
+	}
+
+	return json.Marshal(merge(target, p))
+}
+
+// merge implements the MergePatch() function from section 2 of RFC 7396.
+func merge(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any)
+	}
+
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+			continue
+		}
+
+		t[key] = merge(t[key], value)
+	}
+
+	return t
+}
+
+// Apply applies a list of JSON Patch (RFC 6902) operations to a JSON document, in order, and returns the patched
+// document. If any operation fails then an *Error describing it is returned, and none of the changes are applied.
+func Apply(doc []byte, ops []Operation) ([]byte, error) {
+	var target any
+
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		var err error
+
+		target, err = applyOperation(target, op)
+		if err != nil {
+			return nil, &Error{message: fmt.Sprintf("operation %d (%s %q): %s", i, op.Op, op.Path, err)}
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func applyOperation(doc any, op Operation) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, fmt.Errorf("path: %w", err)
+	}
+
+	// The add, replace and test operations all require a value.
+	var value any
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, errors.New("missing value")
+		}
+
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, errors.New("value must be valid JSON")
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return add(doc, path, value)
+
+	case "remove":
+		doc, _, err = remove(doc, path)
+		return doc, err
+
+	case "replace":
+		// Replacing is the same as removing the existing value and then adding the new one, except that the target
+		// location must exist. The only exception is the whole document, which can always be replaced.
+		if len(path) == 0 {
+			return value, nil
+		}
+
+		doc, _, err = remove(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+
+	case "move":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+
+		// A value can't be moved into one of its own children.
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("cannot move %q into one of its children", op.From)
+		}
+
+		doc, moved, err := remove(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, moved)
+
+	case "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+
+		copied, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, deepCopy(copied))
+
+	case "test":
+		current, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !reflect.DeepEqual(current, value) {
+			return nil, errors.New("current value is not equal to the test value")
+		}
+		return doc, nil
+
+	case "":
+		return nil, errors.New("missing op")
+
+	default:
+		return nil, errors.New("unknown op, must be one of add, remove, replace, move, copy or test")
+	}
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its reference tokens, decoding the ~1 and ~0 escape sequences.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%q must be empty or start with a /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// arrayIndex converts a reference token into an index for an array of length n. When adding to an array the index may
+// be equal to the length of the array, or "-", which both mean the end of the array.
+func arrayIndex(token string, n int, adding bool) (int, error) {
+	if token == "-" && adding {
+		return n, nil
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+
+	if i > n || (i == n && !adding) {
+		return 0, fmt.Errorf("array index %d is out of range", i)
+	}
+
+	return i, nil
+}
+
+// get returns the value at the location referenced by tokens.
+func get(doc any, tokens []string) (any, error) {
+	for _, token := range tokens {
+		switch node := doc.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			doc = value
+
+		case []any:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+
+		default:
+			return nil, fmt.Errorf("cannot reference %q in a value which is not an object or array", token)
+		}
+	}
+
+	return doc, nil
+}
+
+// add inserts a value at the location referenced by tokens and returns the updated document. Adding to an object
+// member that already exists replaces its value, while adding to an array shifts the following elements along.
+func add(doc any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	token := tokens[0]
+
+	switch node := doc.(type) {
+	case map[string]any:
+		if len(tokens) == 1 {
+			node[token] = value
+			return node, nil
+		}
+
+		child, ok := node[token]
+		if !ok {
+			return nil, fmt.Errorf("member %q does not exist", token)
+		}
+
+		child, err := add(child, tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		node[token] = child
+		return node, nil
+
+	case []any:
+		if len(tokens) == 1 {
+			i, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+
+		i, err := arrayIndex(token, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+
+		child, err := add(node[i], tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+
+	default:
+		return nil, fmt.Errorf("cannot reference %q in a value which is not an object or array", token)
+	}
+}
+
+// remove deletes the value at the location referenced by tokens, returning the updated document and the value that
+// was removed. The whole document can't be removed.
+func remove(doc any, tokens []string) (any, any, error) {
+	if len(tokens) == 0 {
+		return nil, nil, errors.New("cannot remove the whole document")
+	}
+
+	token := tokens[0]
+
+	switch node := doc.(type) {
+	case map[string]any:
+		child, ok := node[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("member %q does not exist", token)
+		}
+
+		if len(tokens) == 1 {
+			delete(node, token)
+			return node, child, nil
+		}
+
+		child, removed, err := remove(child, tokens[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		node[token] = child
+		return node, removed, nil
+
+	case []any:
+		i, err := arrayIndex(token, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(tokens) == 1 {
+			removed := node[i]
+			return append(node[:i], node[i+1:]...), removed, nil
+		}
+
+		child, removed, err := remove(node[i], tokens[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		node[i] = child
+		return node, removed, nil
+
+	default:
+		return nil, nil, fmt.Errorf("cannot reference %q in a value which is not an object or array", token)
+	}
+}
+
+// deepCopy returns a copy of a decoded JSON value which doesn't share any maps or slices with the original.
+func deepCopy(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for key, child := range v {
+			c[key] = deepCopy(child)
+		}
+		return c
+
+	case []any:
+		c := make([]any, len(v))
+		for i, child := range v {
+			c[i] = deepCopy(child)
+		}
+		return c
+
+	default:
+		return v
+	}
+}
@@ -0,0 +1,153 @@
+// Package settings fills in a flag.FlagSet from layered sources, so that every setting which can be given as a
+// command-line flag can also be given in a config file or an environment variable. From lowest to highest priority the
+// layers are: the flag's default, the config file, the environment, and the command line.
+package settings
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The sources that a setting's value can come from, as recorded in Sources.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Sources maps the name of each flag to the source of its value.
+type Sources map[string]string
+
+// Options holds the settings for Load() and Print().
+type Options struct {
+	// EnvPrefix is put in front of a flag's name to get the name of its environment variable. The rest of the name is
+	// the flag's name in upper case with hyphens replaced by underscores, so with the prefix "GREENLIGHT_" the -db-dsn
+	// flag is read from GREENLIGHT_DB_DSN.
+	EnvPrefix string
+
+	// FileFlag is the name of the flag which holds the path of the config file. If the flag isn't set, the path is
+	// read from its environment variable instead. If neither is set, there is no config file.
+	FileFlag string
+
+	// CommandLineOnly lists the flags which can only be given on the command line, such as -version, because they
+	// pick what the program does rather than configuring it. They aren't read from the config file or the environment,
+	// and aren't printed.
+	CommandLineOnly []string
+
+	// Secrets lists the flags whose values are never printed.
+	Secrets []string
+}
+
+// Load parses the command-line arguments into the flag set, and then sets every flag which wasn't given on the command
+// line from the environment or, failing that, from the config file. The errors from the config file and the
+// environment are all returned together, one per line, so that they can be fixed in one go.
+func Load(fs *flag.FlagSet, args []string, opts Options) (Sources, error) {
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := Sources{}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		sources[f.Name] = SourceDefault
+	})
+
+	fs.Visit(func(f *flag.Flag) {
+		sources[f.Name] = SourceFlag
+	})
+
+	var errs []error
+
+	// Read the config file first, so that the environment variables can override it.
+	path := ""
+	if opts.FileFlag != "" {
+		if f := fs.Lookup(opts.FileFlag); f != nil {
+			path = f.Value.String()
+		}
+
+		if path == "" {
+			path = os.Getenv(opts.envName(opts.FileFlag))
+		}
+	}
+
+	if path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, setting := range values {
+			f := fs.Lookup(setting.name)
+			if f == nil || opts.commandLineOnly(setting.name) {
+				errs = append(errs, fmt.Errorf("%s: unknown setting %q", path, setting.key))
+				continue
+			}
+
+			if sources[f.Name] == SourceFlag {
+				continue
+			}
+
+			if err := fs.Set(f.Name, setting.value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid value %q for %q: %s", path, setting.value, setting.key, reason(err)))
+				continue
+			}
+
+			sources[f.Name] = SourceFile
+		}
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if sources[f.Name] == SourceFlag || opts.commandLineOnly(f.Name) || f.Name == opts.FileFlag {
+			return
+		}
+
+		name := opts.envName(f.Name)
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+
+		if err := fs.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value %q: %s", name, value, reason(err)))
+			return
+		}
+
+		sources[f.Name] = SourceEnv
+	})
+
+	return sources, errors.Join(errs...)
+}
+
+// The envName() method returns the name of the environment variable for a flag.
+func (opts Options) envName(flagName string) string {
+	return opts.EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// The commandLineOnly() method reports whether a flag can only be given on the command line.
+func (opts Options) commandLineOnly(flagName string) bool {
+	for _, name := range opts.CommandLineOnly {
+		if name == flagName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The reason() function returns the part of an error from setting a flag that is worth showing. The flag package's
+// own values return strconv errors, whose messages name the function that failed, so we just use the cause.
+func reason(err error) string {
+	var numErr *strconv.NumError
+	if errors.As(err, &numErr) {
+		return numErr.Err.Error()
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,140 @@
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A setting is one value from the config file. The key is where it was found, such as "db.max_open_conns", and the
+// name is the flag that it sets, such as "db-max-open-conns".
+type setting struct {
+	key   string
+	name  string
+	value string
+}
+
+// The readFile() function reads the config file, which can be YAML (.yaml or .yml), TOML (.toml) or JSON (.json), and
+// returns its settings sorted by key.
+//
+// A setting's key is the name of its flag, with either hyphens or underscores between the words. Settings can also
+// be grouped in tables, in which case the table names are joined to the key with hyphens. So the -db-max-open-conns
+// flag can be set in YAML with any of:
+//
+//	db-max-open-conns: 25
+//
+//	db:
+//	  max_open_conns: 25
+func readFile(path string) ([]setting, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	var values map[string]any
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &values)
+	case ".toml":
+		err = toml.Unmarshal(content, &values)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(content))
+		dec.UseNumber()
+		err = dec.Decode(&values)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format (use .yaml, .yml, .toml or .json)", path)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	var settings []setting
+
+	err = flatten(&settings, "", "", values)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].key < settings[j].key
+	})
+
+	return settings, nil
+}
+
+// The flatten() function adds the settings from a table to the slice, descending into any tables inside it.
+func flatten(settings *[]setting, keyPrefix, namePrefix string, table map[string]any) error {
+	for key, value := range table {
+		fullKey := keyPrefix + key
+		name := namePrefix + strings.ToLower(strings.ReplaceAll(key, "_", "-"))
+
+		if inner, ok := value.(map[string]any); ok {
+			err := flatten(settings, fullKey+".", name+"-", inner)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		str, err := stringValue(value)
+		if err != nil {
+			return fmt.Errorf("%q: %w", fullKey, err)
+		}
+
+		*settings = append(*settings, setting{key: fullKey, name: name, value: str})
+	}
+
+	return nil
+}
+
+// The stringValue() function converts a value from the config file to the string that its flag is set with. A list
+// becomes its items separated by spaces, which is how the flags that take several values (like -cors-trusted-origins)
+// expect them.
+func stringValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	case nil:
+		return "", nil
+	case []any:
+		items := make([]string, 0, len(v))
+
+		for _, item := range v {
+			str, err := stringValue(item)
+			if err != nil {
+				return "", err
+			}
+
+			if strings.ContainsAny(str, " \t\n") {
+				return "", fmt.Errorf("list items can't contain spaces")
+			}
+
+			items = append(items, str)
+		}
+
+		return strings.Join(items, " "), nil
+	default:
+		return "", fmt.Errorf("unsupported value of type %T", value)
+	}
+}
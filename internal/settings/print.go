@@ -0,0 +1,53 @@
+package settings
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"text/tabwriter"
+)
+
+// redacted replaces the secret values in the printed config.
+const redacted = "xxxxx"
+
+// passwordRX matches the password in a key/value connection string, such as "host=localhost password=pa55word".
+var passwordRX = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// Print writes the value of every flag, along with the source it came from, as an aligned table sorted by name. The
+// values of the flags in opts.Secrets are redacted, as are the passwords in any URLs and connection strings (like a
+// database DSN), so that the output is safe to share.
+func Print(w io.Writer, fs *flag.FlagSet, sources Sources, opts Options) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if opts.commandLineOnly(f.Name) {
+			return
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.Name, opts.redact(f.Name, f.Value.String()), sources[f.Name])
+	})
+
+	return tw.Flush()
+}
+
+// The redact() method returns the value of a flag with any secrets in it replaced.
+func (opts Options) redact(name, value string) string {
+	for _, secret := range opts.Secrets {
+		if name == secret && value != "" {
+			return redacted
+		}
+	}
+
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+			return u.String()
+		}
+	}
+
+	return passwordRX.ReplaceAllString(value, "${1}"+redacted)
+}
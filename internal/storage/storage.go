@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned when there is no file stored under the requested key.
+var ErrNotFound = errors.New("file not found")
+
+// Storage is the interface for somewhere that we can save uploaded files, such as movie posters. Files are identified
+// by a slash-separated key like "posters/1/small". The local filesystem is the only backend at the moment, but others
+// (for example an object store) just need to implement these methods.
+type Storage interface {
+	// Put saves the contents of r under the key, replacing any file which is already there.
+	Put(key string, r io.Reader) error
+	// Open returns the file stored under the key, or an ErrNotFound error. The caller must close it.
+	Open(key string) (io.ReadCloser, error)
+	// Delete removes the file stored under the key. It isn't an error if there is no such file.
+	Delete(key string) error
+}
+
+// Local is a Storage backend which keeps the files in a directory on the local filesystem.
+type Local struct {
+	root string
+}
+
+// NewLocal returns a Local storage backend which keeps its files under the root directory, creating the directory if
+// it doesn't already exist.
+func NewLocal(root string) (*Local, error) {
+	err := os.MkdirAll(root, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{root: root}, nil
+}
+
+// Put writes the file to a temporary file first and then renames it into place, so that anyone reading the file at
+// the same time sees either the old or the new version, and never a partly written one.
+func (l *Local) Put(key string, r io.Reader) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Open returns the file as an *os.File, which also implements io.Seeker.
+func (l *Local) Open(key string) (io.ReadCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+func (l *Local) Delete(key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// The path() method converts a key into a path under the root directory. Keys which would escape the root directory
+// (for example by containing "..") are rejected.
+func (l *Local) path(key string) (string, error) {
+	cleaned := filepath.Clean("/" + key)
+	if cleaned == "/" || cleaned != "/"+key || strings.Contains(key, "\\") {
+		return "", errors.New("storage: invalid key " + key)
+	}
+
+	return filepath.Join(l.root, filepath.FromSlash(cleaned)), nil
+}
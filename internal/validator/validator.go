@@ -2,9 +2,11 @@ package validator
 
 import "regexp"
 
-// Declare a regular expression for sanity checking the format of email addresses.
+// Declare a regular expression for sanity checking the format of email addresses, and another for BCP 47 language
+// tags such as "en", "pt-br" or "zh-hant-tw". Language tags are case-insensitive, but we always store them in lowercase.
 var (
-	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+	EmailRX       = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+	LanguageTagRX = regexp.MustCompile("^[a-z]{2,3}(?:-[a-z0-9]{2,8})*$")
 )
 
 // Define a new validator type which contains a map of validation errors.
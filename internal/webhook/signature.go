@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The headers which are sent with every webhook delivery. The delivery ID is the same for every attempt to send a
+// delivery, so receivers can use it to ignore retries of deliveries which they have already handled.
+const (
+	EventHeader     = "X-Greenlight-Event"
+	DeliveryHeader  = "X-Greenlight-Delivery"
+	TimestampHeader = "X-Greenlight-Timestamp"
+	SignatureHeader = "X-Greenlight-Signature"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrInvalidTimestamp = errors.New("invalid or expired webhook timestamp")
+)
+
+// Sign returns the value of the signature header for a delivery body sent at the given time. The signature is the
+// hex-encoded HMAC-SHA256 of the Unix timestamp, a full stop and the body, keyed with the webhook's secret, and is
+// prefixed with "sha256=" so that other algorithms can be added later. Signing the timestamp along with the body stops
+// an old delivery from being replayed with a new timestamp.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature and timestamp headers of a delivery that has been received, the same way that a
+// receiver should. It returns ErrInvalidTimestamp if the timestamp is more than tolerance away from the current time,
+// and ErrInvalidSignature if the signature doesn't match the body.
+func Verify(secret, timestamp, signature string, body []byte, tolerance time.Duration) error {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+
+	sent := time.Unix(unix, 0)
+
+	if sent.Before(time.Now().Add(-tolerance)) || sent.After(time.Now().Add(tolerance)) {
+		return ErrInvalidTimestamp
+	}
+
+	// Compare in constant time, so that the time taken doesn't give away how much of the signature was right.
+	expected := Sign(secret, sent, body)
+
+	if !strings.HasPrefix(signature, "sha256=") || !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package webhook
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	secret := "0123456789abcdef0123456789abcdef"
+	body := []byte(`{"event":"movie.created","movie":{"id":1,"version":1}}`)
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		signature string
+		body      []byte
+		want      error
+	}{
+		{
+			name:      "Valid",
+			secret:    secret,
+			timestamp: strconv.FormatInt(now.Unix(), 10),
+			signature: Sign(secret, now, body),
+			body:      body,
+		},
+		{
+			name:      "Wrong secret",
+			secret:    "fedcba9876543210fedcba9876543210",
+			timestamp: strconv.FormatInt(now.Unix(), 10),
+			signature: Sign(secret, now, body),
+			body:      body,
+			want:      ErrInvalidSignature,
+		},
+		{
+			name:      "Tampered body",
+			secret:    secret,
+			timestamp: strconv.FormatInt(now.Unix(), 10),
+			signature: Sign(secret, now, body),
+			body:      []byte(`{"event":"movie.deleted","movie":{"id":1,"version":1}}`),
+			want:      ErrInvalidSignature,
+		},
+		{
+			name:      "Timestamp changed after signing",
+			secret:    secret,
+			timestamp: strconv.FormatInt(now.Add(time.Second).Unix(), 10),
+			signature: Sign(secret, now, body),
+			body:      body,
+			want:      ErrInvalidSignature,
+		},
+		{
+			name:      "Missing algorithm prefix",
+			secret:    secret,
+			timestamp: strconv.FormatInt(now.Unix(), 10),
+			signature: Sign(secret, now, body)[len("sha256="):],
+			body:      body,
+			want:      ErrInvalidSignature,
+		},
+		{
+			name:      "Expired timestamp",
+			secret:    secret,
+			timestamp: strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10),
+			signature: Sign(secret, now.Add(-10*time.Minute), body),
+			body:      body,
+			want:      ErrInvalidTimestamp,
+		},
+		{
+			name:      "Future timestamp",
+			secret:    secret,
+			timestamp: strconv.FormatInt(now.Add(10*time.Minute).Unix(), 10),
+			signature: Sign(secret, now.Add(10*time.Minute), body),
+			body:      body,
+			want:      ErrInvalidTimestamp,
+		},
+		{
+			name:      "Malformed timestamp",
+			secret:    secret,
+			timestamp: "yesterday",
+			signature: Sign(secret, now, body),
+			body:      body,
+			want:      ErrInvalidTimestamp,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.timestamp, tt.signature, tt.body, 5*time.Minute)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v; want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version integer NOT NULL,
+    title text NOT NULL,
+    year integer NOT NULL,
+    runtime integer NOT NULL,
+    genres text[] NOT NULL,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (movie_id, version)
+);
+
+-- Record the current version of every existing movie as its first known revision.
+INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres)
+SELECT id, version, title, year, runtime, genres FROM movies
+ON CONFLICT DO NOTHING;
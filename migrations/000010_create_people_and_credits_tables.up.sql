@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS people (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    name text NOT NULL,
+    birth_year integer,
+    biography text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS people_name_idx ON people USING GIN (to_tsvector('simple', name));
+
+CREATE TABLE IF NOT EXISTS movie_credits (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    person_id bigint NOT NULL REFERENCES people ON DELETE CASCADE,
+    role text NOT NULL,
+    character text NOT NULL DEFAULT '',
+    position integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (movie_id, person_id, role)
+);
+
+CREATE INDEX IF NOT EXISTS movie_credits_person_id_idx ON movie_credits (person_id);
+
+INSERT INTO permissions (code)
+VALUES
+    ('people:read'),
+    ('people:write');
+
+-- Everyone who can read movies can also read people.
+INSERT INTO users_permissions
+SELECT users_permissions.user_id, (SELECT id FROM permissions WHERE code = 'people:read')
+FROM users_permissions
+INNER JOIN permissions ON users_permissions.permission_id = permissions.id
+WHERE permissions.code = 'movies:read'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+ALTER TABLE movies DROP COLUMN IF EXISTS poster_updated_at;
+ALTER TABLE movies DROP COLUMN IF EXISTS poster_format;
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS genres (
+    code text PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    name text NOT NULL,
+    aliases text[] NOT NULL DEFAULT '{}',
+    version integer NOT NULL DEFAULT 1
+);
+
+INSERT INTO genres (code, name, aliases)
+VALUES
+    ('action', 'Action', '{}'),
+    ('adventure', 'Adventure', '{}'),
+    ('animation', 'Animation', '{"animated", "cartoon"}'),
+    ('biography', 'Biography', '{"biopic"}'),
+    ('comedy', 'Comedy', '{}'),
+    ('crime', 'Crime', '{}'),
+    ('documentary', 'Documentary', '{}'),
+    ('drama', 'Drama', '{}'),
+    ('family', 'Family', '{}'),
+    ('fantasy', 'Fantasy', '{}'),
+    ('history', 'History', '{"historical"}'),
+    ('horror', 'Horror', '{}'),
+    ('music', 'Music', '{"musical"}'),
+    ('mystery', 'Mystery', '{}'),
+    ('romance', 'Romance', '{"romantic"}'),
+    ('science-fiction', 'Science Fiction', '{"sci-fi", "scifi", "sf"}'),
+    ('thriller', 'Thriller', '{}'),
+    ('war', 'War', '{}'),
+    ('western', 'Western', '{}')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO permissions (code)
+VALUES ('genres:write');
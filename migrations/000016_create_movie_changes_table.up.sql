@@ -0,0 +1,61 @@
+CREATE TABLE IF NOT EXISTS movie_changes (
+    id bigserial PRIMARY KEY,
+    changed_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    movie_id bigint NOT NULL,
+    version integer NOT NULL,
+    operation text NOT NULL,
+    txid xid8 NOT NULL DEFAULT pg_current_xact_id(),
+    CONSTRAINT movie_changes_operation_check CHECK (operation IN ('create', 'update', 'delete'))
+);
+
+CREATE INDEX IF NOT EXISTS movie_changes_changed_at_idx ON movie_changes (changed_at);
+CREATE INDEX IF NOT EXISTS movie_changes_txid_id_idx ON movie_changes (txid, id);
+
+-- Record every visible change to a movie in movie_changes, and notify the listeners on the movie_changes channel.
+-- Moving a movie to the trash counts as deleting it, and restoring it counts as creating it again. Changes to movies
+-- which are already in the trash (including purging them) aren't visible, so they aren't recorded.
+--
+-- Concurrent transactions can commit in a different order to the one that they took their change IDs in, so the feed
+-- isn't read in ID order. Instead each change records the ID of the transaction that made it, and the feed is read in
+-- (txid, id) order, but only up to the oldest transaction which is still running (see MovieChangeModel.GetAllAfter).
+-- Every change before that point has been committed (or rolled back) for good, so a reader never skips a change by
+-- resuming from a later one, and writers don't have to wait for each other.
+CREATE OR REPLACE FUNCTION record_movie_change() RETURNS trigger AS $$
+DECLARE
+    change_operation text;
+    changed_movie movies%ROWTYPE;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        change_operation := 'create';
+        changed_movie := NEW;
+    ELSIF TG_OP = 'DELETE' THEN
+        IF OLD.deleted_at IS NOT NULL THEN
+            RETURN NULL;
+        END IF;
+        change_operation := 'delete';
+        changed_movie := OLD;
+    ELSIF OLD IS NOT DISTINCT FROM NEW OR (OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NOT NULL) THEN
+        RETURN NULL;
+    ELSIF NEW.deleted_at IS NOT NULL THEN
+        change_operation := 'delete';
+        changed_movie := NEW;
+    ELSIF OLD.deleted_at IS NOT NULL THEN
+        change_operation := 'create';
+        changed_movie := NEW;
+    ELSE
+        change_operation := 'update';
+        changed_movie := NEW;
+    END IF;
+
+    INSERT INTO movie_changes (movie_id, version, operation)
+    VALUES (changed_movie.id, changed_movie.version, change_operation);
+
+    PERFORM pg_notify('movie_changes', changed_movie.id::text);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER movies_record_change
+    AFTER INSERT OR UPDATE OR DELETE ON movies
+    FOR EACH ROW EXECUTE FUNCTION record_movie_change();
@@ -0,0 +1,6 @@
+DELETE FROM permissions WHERE code = 'webhooks:write';
+
+DROP TRIGGER IF EXISTS movie_changes_queue_webhook_deliveries ON movie_changes;
+DROP FUNCTION IF EXISTS queue_webhook_deliveries();
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
@@ -0,0 +1,63 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    url text NOT NULL,
+    secret text NOT NULL,
+    events text[] NOT NULL,
+    active boolean NOT NULL DEFAULT true,
+    version integer NOT NULL DEFAULT 1
+);
+
+-- webhook_deliveries is the outbox of webhook calls. Each row is one event to be sent to one webhook, along with the
+-- outcome of the latest attempt to send it. Pending deliveries are retried until they succeed or run out of attempts.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    webhook_id bigint NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+    event text NOT NULL,
+    payload jsonb NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    last_attempt_at timestamp(0) with time zone,
+    response_status integer,
+    last_error text NOT NULL DEFAULT '',
+    CONSTRAINT webhook_deliveries_status_check CHECK (status IN ('pending', 'succeeded', 'failed'))
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, id);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+-- Add a delivery to the outbox for every active webhook that subscribes to the event, whenever a movie change is
+-- recorded. This runs in the same transaction as the change to the movie, so a delivery is queued if and only if the
+-- change is committed.
+CREATE OR REPLACE FUNCTION queue_webhook_deliveries() RETURNS trigger AS $$
+DECLARE
+    event_name text;
+BEGIN
+    event_name := CASE NEW.operation
+        WHEN 'create' THEN 'movie.created'
+        WHEN 'update' THEN 'movie.updated'
+        ELSE 'movie.deleted'
+    END;
+
+    INSERT INTO webhook_deliveries (webhook_id, event, payload)
+    SELECT id, event_name, jsonb_build_object(
+        'event', event_name,
+        'occurred_at', NEW.changed_at,
+        'change_id', NEW.id,
+        'movie', jsonb_build_object('id', NEW.movie_id, 'version', NEW.version)
+    )
+    FROM webhooks
+    WHERE active AND event_name = ANY (events);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER movie_changes_queue_webhook_deliveries
+    AFTER INSERT ON movie_changes
+    FOR EACH ROW EXECUTE FUNCTION queue_webhook_deliveries();
+
+INSERT INTO permissions (code)
+VALUES ('webhooks:write');
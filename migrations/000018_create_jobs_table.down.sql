@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'jobs:write';
+
+DROP TABLE IF EXISTS jobs;
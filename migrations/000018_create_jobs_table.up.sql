@@ -0,0 +1,24 @@
+-- jobs is the background job queue. A job is queued until a worker claims it, and then running until the worker either
+-- finishes it (succeeded), puts it back to be retried later (queued again), or gives up on it (dead). While a job is
+-- queued, run_at is when it becomes due, and while it is running, run_at is when the worker's lease on it runs out, after
+-- which another worker can claim it (as the first one has presumably crashed).
+CREATE TABLE IF NOT EXISTS jobs (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    kind text NOT NULL,
+    payload jsonb NOT NULL,
+    status text NOT NULL DEFAULT 'queued',
+    attempts integer NOT NULL DEFAULT 0,
+    max_attempts integer NOT NULL,
+    run_at timestamp with time zone NOT NULL DEFAULT now(),
+    last_error text NOT NULL DEFAULT '',
+    finished_at timestamp(0) with time zone,
+    CONSTRAINT jobs_status_check CHECK (status IN ('queued', 'running', 'succeeded', 'dead')),
+    CONSTRAINT jobs_max_attempts_check CHECK (max_attempts > 0)
+);
+
+CREATE INDEX IF NOT EXISTS jobs_run_at_idx ON jobs (run_at) WHERE status IN ('queued', 'running');
+CREATE INDEX IF NOT EXISTS jobs_status_idx ON jobs (status, id);
+
+INSERT INTO permissions (code)
+VALUES ('jobs:write');
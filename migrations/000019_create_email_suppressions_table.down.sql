@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'mail:write';
+
+DROP TABLE IF EXISTS email_suppressions;
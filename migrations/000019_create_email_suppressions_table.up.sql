@@ -0,0 +1,10 @@
+-- email_suppressions is the list of addresses that emails must not be sent to, because they hard-bounced (the mail
+-- server said that the address doesn't exist or can't receive email). The reason holds the server's response.
+CREATE TABLE IF NOT EXISTS email_suppressions (
+    email citext PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    reason text NOT NULL
+);
+
+INSERT INTO permissions (code)
+VALUES ('mail:write');